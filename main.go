@@ -1,6 +1,7 @@
 package main
 
 import (
+	"easy-http-proxy-pool/pkg/admin"
 	"easy-http-proxy-pool/pkg/conf"
 	"easy-http-proxy-pool/pkg/logger"
 	"easy-http-proxy-pool/pkg/proxy"
@@ -31,7 +32,24 @@ func main() {
 		Level: level,
 	})
 	slog.SetDefault(slog.New(logHandler))
-	proxyConfig, _ := conf.ReadFromFile(conf.ConfigPath)
-	server := proxy.NewProxyServer(proxyConfig)
+	proxyConfig, err := conf.ReadFromFile(conf.ConfigPath)
+	if err != nil {
+		slog.Error(fmt.Sprintf("加载配置失败: %s", err))
+		os.Exit(1)
+	}
+	server, err := proxy.NewProxyServer(proxyConfig)
+	if err != nil {
+		slog.Error(fmt.Sprintf("初始化代理服务失败: %s", err))
+		os.Exit(1)
+	}
+	watcher := conf.NewWatcher(conf.ConfigPath, server.Reload)
+	watcher.Start()
+	defer watcher.Close()
+	if conf.AdminAddr != "" {
+		go admin.NewServer(server.Pools()).Listen(conf.AdminAddr)
+	}
+	if conf.Socks5Addr != "" {
+		go server.ListenSocks5(conf.Socks5Addr)
+	}
 	server.Listen(fmt.Sprintf("%s:%s", conf.Host, conf.Port))
 }
@@ -0,0 +1,195 @@
+package admin
+
+import (
+	"easy-http-proxy-pool/pkg/metrics"
+	"easy-http-proxy-pool/pkg/middleware"
+	"easy-http-proxy-pool/pkg/pool"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Server 管理接口, 独立于代理端口监听
+// /api 下的接口均可通过参数 pool 指定命名代理池, 默认为默认代理池
+// GET    /api/pools                   列出代理池
+// GET    /api/sources                 列出代理源
+// POST   /api/sources/{name}/enable   强制启用代理源
+// POST   /api/sources/{name}/disable  强制禁用代理源, 参数 reason, duration(如 10m)
+// POST   /api/sources/{name}/fetch    立即从代理源加载地址
+// POST   /api/fetch                   立即从第一个可用的代理源加载地址
+// GET    /api/addresses               列出缓存的地址
+// DELETE /api/addresses?addr=xxx      移除指定地址, 可以使用列出地址时返回的地址
+// 地址中的认证信息不会通过接口返回
+// GET    /metrics                     Prometheus 指标
+type Server struct {
+	pools *pool.Group
+	mux   *http.ServeMux
+}
+
+// PoolState 代理池状态
+type PoolState struct {
+	Name      string `json:"name"`
+	Addresses int    `json:"addresses"`
+	Sessions  int    `json:"sessions"`
+}
+
+func NewServer(p *pool.Group) *Server {
+	s := &Server{pools: p, mux: http.NewServeMux()}
+	s.mux.HandleFunc("GET /api/pools", s.listPools)
+	s.mux.HandleFunc("GET /api/sources", s.listSources)
+	s.mux.HandleFunc("POST /api/sources/{name}/enable", s.enableSource)
+	s.mux.HandleFunc("POST /api/sources/{name}/disable", s.disableSource)
+	s.mux.HandleFunc("POST /api/sources/{name}/fetch", s.fetchSource)
+	s.mux.HandleFunc("POST /api/fetch", s.fetchSource)
+	s.mux.HandleFunc("GET /api/addresses", s.listAddresses)
+	s.mux.HandleFunc("DELETE /api/addresses", s.evictAddress)
+	s.mux.Handle("GET /metrics", metrics.Handler())
+	return s
+}
+
+// Handle 注册额外的接口
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Listen 启动管理接口, 阻塞直到监听失败
+func (s *Server) Listen(addr string) {
+	slog.Info(fmt.Sprintf("管理接口启动 %s", addr))
+	server := &http.Server{
+		Addr:    addr,
+		Handler: middleware.Recovery(s),
+	}
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error(fmt.Sprintf("管理接口启动失败: %v", err))
+	}
+}
+
+// writeJSON 输出 json 响应
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError 输出错误响应
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// lookupPool 按参数 pool 查找代理池, 不存在时输出 404
+func (s *Server) lookupPool(w http.ResponseWriter, r *http.Request) (*pool.DynamicPool, bool) {
+	name := r.FormValue("pool")
+	p, ok := s.pools.Get(name)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("代理池不存在: %s", name))
+	}
+	return p, ok
+}
+
+func (s *Server) listPools(w http.ResponseWriter, r *http.Request) {
+	def := s.pools.Default()
+	result := []PoolState{{Name: "", Addresses: def.Size(), Sessions: def.SessionCount()}}
+	for _, name := range s.pools.Names() {
+		if p, ok := s.pools.Get(name); ok {
+			result = append(result, PoolState{Name: name, Addresses: p.Size(), Sessions: p.SessionCount()})
+		}
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *Server) listSources(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.lookupPool(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, p.Sources())
+}
+
+func (s *Server) listAddresses(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.lookupPool(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, p.Addresses())
+}
+
+func (s *Server) enableSource(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.lookupPool(w, r)
+	if !ok {
+		return
+	}
+	if err := p.EnableSource(r.PathValue("name")); err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, p.Sources())
+}
+
+func (s *Server) disableSource(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.lookupPool(w, r)
+	if !ok {
+		return
+	}
+	reason := r.FormValue("reason")
+	if reason == "" {
+		reason = "手动禁用"
+	}
+	var duration time.Duration
+	if v := r.FormValue("duration"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		duration = d
+	}
+	if err := p.DisableSource(r.PathValue("name"), reason, duration); err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, p.Sources())
+}
+
+func (s *Server) fetchSource(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.lookupPool(w, r)
+	if !ok {
+		return
+	}
+	addrs, err := p.Refetch(r.PathValue("name"))
+	if errors.Is(err, pool.ErrSourceNotFound) {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	for i, addr := range addrs {
+		addrs[i] = pool.RedactAddress(addr)
+	}
+	writeJSON(w, http.StatusOK, addrs)
+}
+
+func (s *Server) evictAddress(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.lookupPool(w, r)
+	if !ok {
+		return
+	}
+	addr := r.FormValue("addr")
+	if addr == "" {
+		writeError(w, http.StatusBadRequest, errors.New("缺少参数 addr"))
+		return
+	}
+	if !p.EvictAddress(addr) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("地址不存在: %s", addr))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
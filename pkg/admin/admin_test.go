@@ -0,0 +1,125 @@
+package admin
+
+import (
+	"easy-http-proxy-pool/pkg/conf"
+	"easy-http-proxy-pool/pkg/pool"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestServer(t *testing.T) (*Server, *pool.Group) {
+	t.Helper()
+	g, err := pool.NewGroup(&conf.Config{
+		ProxySources: []*conf.ProxySource{
+			{Name: "a", Type: "fixed", TTL: time.Minute, FixedAddr: []string{"127.0.0.1:8080"}, Username: "user", Password: "secret"},
+			{Name: "b", Type: "fixed", TTL: time.Minute, FixedAddr: []string{"127.0.0.1:8081"}},
+		},
+		Pools: []*conf.PoolConfig{{Name: "named", Sources: []string{"b"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(g.Close)
+	return NewServer(g), g
+}
+
+// call 调用接口, 返回状态码与响应体
+func call(s *Server, method string, target string) (int, string) {
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+	return rec.Code, rec.Body.String()
+}
+
+func TestAdminServer(t *testing.T) {
+	t.Run("列出代理池、代理源与地址, 地址不包含认证信息", func(t *testing.T) {
+		s, _ := newTestServer(t)
+		if code, body := call(s, http.MethodPost, "/api/sources/a/fetch"); code != http.StatusOK || strings.Contains(body, "secret") {
+			t.Fatal(code, body)
+		}
+		code, body := call(s, http.MethodGet, "/api/addresses")
+		if code != http.StatusOK {
+			t.Fatal(code, body)
+		}
+		var addrs []pool.AddressState
+		json.Unmarshal([]byte(body), &addrs)
+		if len(addrs) != 1 || addrs[0].Addr != "xxxxx@127.0.0.1:8080" || strings.Contains(body, "secret") {
+			t.Fatal(body)
+		}
+		var pools []PoolState
+		_, body = call(s, http.MethodGet, "/api/pools")
+		json.Unmarshal([]byte(body), &pools)
+		if len(pools) != 2 || pools[0].Addresses != 1 || pools[1].Name != "named" {
+			t.Fatal(body)
+		}
+		var sources []pool.SourceState
+		_, body = call(s, http.MethodGet, "/api/sources?pool=named")
+		json.Unmarshal([]byte(body), &sources)
+		if len(sources) != 1 || sources[0].Name != "b" {
+			t.Fatal(body)
+		}
+	})
+	t.Run("禁用与启用代理源", func(t *testing.T) {
+		s, g := newTestServer(t)
+		if code, body := call(s, http.MethodPost, "/api/sources/a/disable?reason=test&duration=10m"); code != http.StatusOK {
+			t.Fatal(code, body)
+		}
+		if state := g.Default().Sources()[0]; !state.Disabled || state.DisabledReason != "test" || state.DisabledFor != "10m0s" {
+			t.Fatal(state)
+		}
+		if code, _ := call(s, http.MethodPost, "/api/sources/a/disable?duration=abc"); code != http.StatusBadRequest {
+			t.Fatal(code)
+		}
+		if code, body := call(s, http.MethodPost, "/api/sources/a/enable"); code != http.StatusOK {
+			t.Fatal(code, body)
+		}
+		if g.Default().Sources()[0].Disabled {
+			t.Fatal("source should be enabled")
+		}
+	})
+	t.Run("立即提取地址", func(t *testing.T) {
+		s, g := newTestServer(t)
+		if code, body := call(s, http.MethodPost, "/api/fetch?pool=named"); code != http.StatusOK || !strings.Contains(body, "127.0.0.1:8081") {
+			t.Fatal(code, body)
+		}
+		if p, _ := g.Get("named"); p.Size() != 1 {
+			t.Fatal(p.Size())
+		}
+	})
+	t.Run("移除地址", func(t *testing.T) {
+		s, g := newTestServer(t)
+		call(s, http.MethodPost, "/api/sources/a/fetch")
+		if code, _ := call(s, http.MethodDelete, "/api/addresses"); code != http.StatusBadRequest {
+			t.Fatal(code)
+		}
+		if code, _ := call(s, http.MethodDelete, "/api/addresses?addr=127.0.0.1:9999"); code != http.StatusNotFound {
+			t.Fatal(code)
+		}
+		if code, _ := call(s, http.MethodDelete, "/api/addresses?addr=xxxxx@127.0.0.1:8080"); code != http.StatusNoContent {
+			t.Fatal(code)
+		}
+		if g.Default().Size() != 0 {
+			t.Fatal(g.Default().Size())
+		}
+	})
+	t.Run("代理池或代理源不存在", func(t *testing.T) {
+		s, _ := newTestServer(t)
+		requests := [][2]string{
+			{http.MethodGet, "/api/sources?pool=missing"},
+			{http.MethodGet, "/api/addresses?pool=missing"},
+			{http.MethodPost, "/api/fetch?pool=missing"},
+			{http.MethodPost, "/api/sources/missing/enable"},
+			{http.MethodPost, "/api/sources/missing/disable"},
+			{http.MethodPost, "/api/sources/missing/fetch"},
+			{http.MethodPost, "/api/sources/a/fetch?pool=named"},
+		}
+		for _, req := range requests {
+			if code, body := call(s, req[0], req[1]); code != http.StatusNotFound {
+				t.Fatal(req, code, body)
+			}
+		}
+	})
+}
@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"easy-http-proxy-pool/pkg/conf"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Authenticator 客户端认证, 配置加载或重新加载时构建
+type Authenticator struct {
+	users map[string]string
+}
+
+// New 根据配置构建认证器, 未配置用户时返回 nil 表示不需要认证
+func New(c *conf.Auth) (*Authenticator, error) {
+	if c == nil {
+		return nil, nil
+	}
+	users := make(map[string]string, len(c.Users))
+	for name, password := range c.Users {
+		users[name] = password
+	}
+	if c.HtpasswdFile != "" {
+		data, err := os.ReadFile(c.HtpasswdFile)
+		if err != nil {
+			return nil, fmt.Errorf("读取 htpasswd 文件失败: %w", err)
+		}
+		if err := parseHtpasswd(data, users); err != nil {
+			return nil, err
+		}
+	}
+	if len(users) == 0 {
+		return nil, nil
+	}
+	return &Authenticator{users: users}, nil
+}
+
+// parseHtpasswd 解析 user:hash 格式, 忽略空行与 # 注释, 不支持的密码格式返回错误
+func parseHtpasswd(data []byte, users map[string]string) error {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		name, hashed, ok := strings.Cut(text, ":")
+		if !ok || name == "" {
+			return fmt.Errorf("htpasswd 第 %d 行格式错误", line)
+		}
+		if !supportedHash(hashed) {
+			return fmt.Errorf("htpasswd 第 %d 行密码格式不支持, 仅支持明文、{SHA} 与 $apr1$", line)
+		}
+		users[name] = hashed
+	}
+	return scanner.Err()
+}
+
+// Check 校验用户名与密码
+func (a *Authenticator) Check(username string, password string) bool {
+	hashed, ok := a.users[username]
+	if !ok {
+		return false
+	}
+	return verifyPassword(hashed, password)
+}
+
+// ParseProxyAuthorization 解析 Proxy-Authorization: Basic xxx
+func ParseProxyAuthorization(header string) (username string, password string, ok bool) {
+	const prefix = "Basic "
+	if len(header) < len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return "", "", false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(header[len(prefix):])
+	if err != nil {
+		return "", "", false
+	}
+	username, password, ok = strings.Cut(string(decoded), ":")
+	return username, password, ok
+}
+
+// sessionSeparator 用户名中会话标识的分隔符, 如 alice-session-abc
+const sessionSeparator = "-session-"
+
+// SplitSession 拆分 user-session-xxx 形式的用户名, 不含会话标识时 session 为空
+func SplitSession(username string) (user string, session string) {
+	user, session, _ = strings.Cut(username, sessionSeparator)
+	return user, session
+}
@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"easy-http-proxy-pool/pkg/conf"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVerifyPassword(t *testing.T) {
+	t.Run("htpasswd 密码格式", func(t *testing.T) {
+		cases := map[string]string{
+			"secret":                                "明文",
+			"{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=":     "sha1",
+			"$apr1$r31vNHmS$Ve5sKZdlwIKpesJhw6nQ90": "apr1",
+		}
+		for hashed, name := range cases {
+			if !verifyPassword(hashed, "secret") {
+				t.Fatal(name, "should match")
+			}
+			if verifyPassword(hashed, "Secret") {
+				t.Fatal(name, "should not match")
+			}
+		}
+	})
+}
+
+func TestNew(t *testing.T) {
+	write := func(t *testing.T, content string) string {
+		path := filepath.Join(t.TempDir(), "htpasswd")
+		os.WriteFile(path, []byte(content), 0600)
+		return path
+	}
+	t.Run("加载 htpasswd 文件", func(t *testing.T) {
+		a, err := New(&conf.Auth{
+			Users:        map[string]string{"alice": "secret"},
+			HtpasswdFile: write(t, "# comment\n\nbob:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\ncarol:$apr1$r31vNHmS$Ve5sKZdlwIKpesJhw6nQ90\n"),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, user := range []string{"alice", "bob", "carol"} {
+			if !a.Check(user, "secret") || a.Check(user, "wrong") {
+				t.Fatal(user)
+			}
+		}
+	})
+	t.Run("拒绝不支持的密码格式", func(t *testing.T) {
+		lines := []string{
+			"bob:$2y$05$c4WoMPo3SXsafkva.HHa6uXQZWr7oboPiC2bT/r7q1BB8I2s0BRqC",
+			"bob:$6$salt$hash",
+			"bob:{SSHA}abcdef",
+		}
+		for _, line := range lines {
+			if _, err := New(&conf.Auth{HtpasswdFile: write(t, line+"\n")}); err == nil {
+				t.Fatal(line, "should be rejected")
+			}
+		}
+	})
+}
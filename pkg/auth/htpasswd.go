@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"strings"
+)
+
+// htpasswd 密码格式, 支持明文、{SHA} 与 $apr1$ (htpasswd -p / -s / -m)
+const (
+	shaPrefix  = "{SHA}"
+	apr1Prefix = "$apr1$"
+	itoa64     = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+)
+
+// supportedHash 是否为支持的 htpasswd 密码格式
+// 以 $ 或 { 开头的其他格式(bcrypt、$5$、$6$、{SSHA} 等)无法校验, 不能当作明文比较, 否则哈希本身就能通过认证
+func supportedHash(hashed string) bool {
+	if strings.HasPrefix(hashed, shaPrefix) || strings.HasPrefix(hashed, apr1Prefix) {
+		return true
+	}
+	return !strings.HasPrefix(hashed, "$") && !strings.HasPrefix(hashed, "{")
+}
+
+// verifyPassword 校验密码, hashed 为配置中的密码或 htpasswd 中的哈希
+func verifyPassword(hashed string, password string) bool {
+	var expected string
+	switch {
+	case strings.HasPrefix(hashed, shaPrefix):
+		sum := sha1.Sum([]byte(password))
+		expected = shaPrefix + base64.StdEncoding.EncodeToString(sum[:])
+	case strings.HasPrefix(hashed, apr1Prefix):
+		salt, _, _ := strings.Cut(strings.TrimPrefix(hashed, apr1Prefix), "$")
+		expected = apr1(password, salt)
+	default:
+		expected = password
+	}
+	return subtle.ConstantTimeCompare([]byte(hashed), []byte(expected)) == 1
+}
+
+// apr1 Apache 版本的 MD5-crypt
+func apr1(password string, salt string) string {
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+	pw := []byte(password)
+	h := md5.New()
+	h.Write(pw)
+	h.Write([]byte(apr1Prefix))
+	h.Write([]byte(salt))
+	alt := md5.Sum([]byte(password + salt + password))
+	for i := len(pw); i > 0; i -= 16 {
+		h.Write(alt[:min(i, 16)])
+	}
+	for i := len(pw); i > 0; i >>= 1 {
+		if i&1 == 1 {
+			h.Write([]byte{0})
+		} else {
+			h.Write(pw[:1])
+		}
+	}
+	final := h.Sum(nil)
+	for i := 0; i < 1000; i++ {
+		h := md5.New()
+		if i&1 == 1 {
+			h.Write(pw)
+		} else {
+			h.Write(final)
+		}
+		if i%3 != 0 {
+			h.Write([]byte(salt))
+		}
+		if i%7 != 0 {
+			h.Write(pw)
+		}
+		if i&1 == 1 {
+			h.Write(final)
+		} else {
+			h.Write(pw)
+		}
+		final = h.Sum(nil)
+	}
+	var out strings.Builder
+	to64 := func(v uint32, n int) {
+		for ; n > 0; n-- {
+			out.WriteByte(itoa64[v&0x3f])
+			v >>= 6
+		}
+	}
+	for _, g := range [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}} {
+		to64(uint32(final[g[0]])<<16|uint32(final[g[1]])<<8|uint32(final[g[2]]), 4)
+	}
+	to64(uint32(final[11]), 2)
+	return apr1Prefix + salt + "$" + out.String()
+}
@@ -2,32 +2,237 @@ package conf
 
 import (
 	"flag"
+	"fmt"
 	"gopkg.in/yaml.v3"
-	"io"
-	"log"
 	"log/slog"
 	"os"
 	"path"
 	"time"
 )
 
+// JSONPath json 类型代理源的字段路径, 以 . 分隔, 数组下标使用数字, 如 data.list
+// JSONPath.List 地址列表所在路径, 为空表示响应本身就是列表
+// JSONPath.Addr 列表元素中 ip:port 字段, 与 IP + Port 二选一
+// JSONPath.IP JSONPath.Port 列表元素中 ip 与端口字段
+// JSONPath.Username JSONPath.Password 列表元素中认证信息字段, 可选
+// JSONPath.Expire 列表元素中过期时间字段, 可选, 支持时间戳与时间字符串, 优先于 ttl
+// JSONPath.ExpireLayout 过期时间字符串格式, 默认 2006-01-02 15:04:05
+type JSONPath struct {
+	List         string `json:"list" yaml:"list"`
+	Addr         string `json:"addr" yaml:"addr"`
+	IP           string `json:"ip" yaml:"ip"`
+	Port         string `json:"port" yaml:"port"`
+	Username     string `json:"username" yaml:"username"`
+	Password     string `json:"password" yaml:"password"`
+	Expire       string `json:"expire" yaml:"expire"`
+	ExpireLayout string `json:"expireLayout" yaml:"expireLayout"`
+}
+
 // ProxySource 代理源
-// ProxySource.Type 类型
+// ProxySource.Name 名称, 可选, 代理池、规则与管理接口按名称引用, 不能重复
+// ProxySource.Type 类型 common|fixed|json
 // ProxySource.FetchURL 加载链接
 // ProxySource.TTL 过期时间 秒
+// ProxySource.Weight 权重, 仅 weighted 策略使用, 默认为 1
+// ProxySource.Username ProxySource.Password 代理认证信息, 地址自带 user:pass@ 时以地址为准
+// ProxySource.Protocol 上游代理协议 http|https|socks5|socks5h, 默认 http
+// ProxySource.JSON json 类型代理源的字段路径
 type ProxySource struct {
 	Name      string        `json:"name" yaml:"name"`
 	Type      string        `json:"type" yaml:"type"`
 	FetchURL  string        `json:"fetchURL" yaml:"fetchURL"`
 	FixedAddr []string      `json:"fixedAddr" yaml:"fixedAddr"`
 	TTL       time.Duration `json:"ttl" yaml:"ttl"`
+	Weight    int           `json:"weight" yaml:"weight"`
+	Username  string        `json:"username" yaml:"username"`
+	Password  string        `json:"password" yaml:"password"`
+	Protocol  string        `json:"protocol" yaml:"protocol"`
+	JSON      *JSONPath     `json:"json" yaml:"json"`
+}
+
+// HealthCheck 代理地址健康检查
+// HealthCheck.Type 检查方式 tcp|connect|get
+// HealthCheck.Target connect 时为 host:port, get 时为完整 URL
+// HealthCheck.Interval 检查间隔
+// HealthCheck.Timeout 单次检查超时
+// HealthCheck.MaxLatency 延迟超过该值的地址仅在没有更快地址时使用, 0 表示不限制
+// HealthCheck.Quarantine 检查失败后的隔离时长, 0 表示直接移除
+type HealthCheck struct {
+	Type       string        `json:"type" yaml:"type"`
+	Target     string        `json:"target" yaml:"target"`
+	Interval   time.Duration `json:"interval" yaml:"interval"`
+	Timeout    time.Duration `json:"timeout" yaml:"timeout"`
+	MaxLatency time.Duration `json:"maxLatency" yaml:"maxLatency"`
+	Quarantine time.Duration `json:"quarantine" yaml:"quarantine"`
+}
+
+// Auth 客户端认证, 用户名与密码通过 Proxy-Authorization Basic 认证
+// Auth.Users 用户名与密码
+// Auth.HtpasswdFile htpasswd 格式文件, 支持明文、{SHA} 与 $apr1$ 密码
+type Auth struct {
+	Users        map[string]string `json:"users" yaml:"users"`
+	HtpasswdFile string            `json:"htpasswdFile" yaml:"htpasswdFile"`
+}
+
+// Rule 路由规则, 按顺序匹配, 条件全部满足时命中, 未配置的条件不参与匹配
+// Rule.Domain 域名后缀, 匹配域名本身及其子域名
+// Rule.Host 完整主机名
+// Rule.Regex 主机名正则
+// Rule.CIDR 目标 IP 网段, 目标为域名时会解析 IP
+// Rule.Port 目标端口
+// Rule.Method 请求方法, CONNECT 请求的方法为 CONNECT
+// Rule.Path URL 路径前缀, CONNECT 请求没有路径
+// Rule.Action 动作 direct|proxy|block|reject
+// Rule.Pool proxy 动作使用的命名代理池, 为空表示默认代理池
+// Rule.Sources proxy 动作限定使用的代理源名称, 为空表示代理池中的全部代理源
+// Rule.Status reject 动作返回的状态码, 默认 403
+// Rule.Fallback proxy 动作代理不可用时的处理 direct(默认, 降级直连)|fail(返回 502)|retry-other-proxy(更换代理重试)
+// Rule.MaxAttempts retry-other-proxy 最多尝试的代理地址数量, 默认 3
+// Rule.Timeouts 命中该规则的请求使用的超时, 未配置的项使用全局配置
+// Rule.MITM 命中该规则的 CONNECT 请求解密后按普通 http 请求重新匹配规则, 需要配置 mitm
+// Rule.Detect 经代理的 http 响应的封禁检测, CONNECT 隧道只有解密后才能检测
+type Rule struct {
+	Domain      string    `json:"domain" yaml:"domain"`
+	Host        string    `json:"host" yaml:"host"`
+	Regex       string    `json:"regex" yaml:"regex"`
+	CIDR        string    `json:"cidr" yaml:"cidr"`
+	Port        int       `json:"port" yaml:"port"`
+	Method      string    `json:"method" yaml:"method"`
+	Path        string    `json:"path" yaml:"path"`
+	Action      string    `json:"action" yaml:"action"`
+	Pool        string    `json:"pool" yaml:"pool"`
+	Sources     []string  `json:"sources" yaml:"sources"`
+	Status      int       `json:"status" yaml:"status"`
+	Fallback    string    `json:"fallback" yaml:"fallback"`
+	MaxAttempts int       `json:"maxAttempts" yaml:"maxAttempts"`
+	Timeouts    *Timeouts `json:"timeouts" yaml:"timeouts"`
+	MITM        bool      `json:"mitm" yaml:"mitm"`
+	Detect      *Detect   `json:"detect" yaml:"detect"`
+}
+
+// Detect 封禁检测, 目标站点封禁出口 IP 时常返回验证码页面、403/429 或跳转到验证页, 任一条件满足即视为封禁
+// Detect.Status 状态码
+// Detect.Headers 响应中出现即视为封禁的头部名称
+// Detect.Body 响应体正则, 只检查前 BodyBytes 字节, gzip 响应会先解压
+// Detect.BodyBytes 检查的响应体字节数, 默认 4096
+// Detect.Location 跳转地址正则
+// Detect.Retry 检测到封禁后是否更换代理地址重试, 次数受重试配置限制, 请求体过大无法重放时不重试
+type Detect struct {
+	Status    []int    `json:"status" yaml:"status"`
+	Headers   []string `json:"headers" yaml:"headers"`
+	Body      string   `json:"body" yaml:"body"`
+	BodyBytes int      `json:"bodyBytes" yaml:"bodyBytes"`
+	Location  string   `json:"location" yaml:"location"`
+	Retry     bool     `json:"retry" yaml:"retry"`
+}
+
+// Session 会话保持, 客户端通过请求头或 user-session-xxx 形式的用户名传递会话标识
+// Session.Header 传递会话标识的请求头, 默认 X-Proxy-Session
+// Session.TTL 会话空闲过期时间, 默认 10 分钟
+// Session.MaxSize 每个代理池最多保存的会话数量, 超出时淘汰最久未使用的会话, 默认 10000
+type Session struct {
+	Header  string        `json:"header" yaml:"header"`
+	TTL     time.Duration `json:"ttl" yaml:"ttl"`
+	MaxSize int           `json:"maxSize" yaml:"maxSize"`
+}
+
+// Retry 普通 http 请求经远程代理失败时更换代理地址重试, 仅对幂等方法生效
+// Retry.MaxAttempts 最多尝试的代理地址数量, 默认 2, 1 表示不重试
+// Retry.Deadline 包括重试在内的总耗时上限, 默认 30 秒, 超过后不再重试
+type Retry struct {
+	MaxAttempts int           `json:"maxAttempts" yaml:"maxAttempts"`
+	Deadline    time.Duration `json:"deadline" yaml:"deadline"`
+}
+
+// Forwarded 转发给目标站点的头部, 默认全部移除, 不暴露客户端 IP
+// Forwarded.XForwardedFor X-Forwarded-For 处理方式 strip(移除, 同时移除 X-Real-Ip 与 Forwarded)|add(追加客户端 IP)|pass(原样转发)
+// Forwarded.Via Via 处理方式 strip|add|pass, 同时作用于请求与响应
+type Forwarded struct {
+	XForwardedFor string `json:"xForwardedFor" yaml:"xForwardedFor"`
+	Via           string `json:"via" yaml:"via"`
+}
+
+// Timeouts 超时, 未配置或为 0 的项使用默认值
+// Timeouts.Dial 连接上游代理或目标站点的超时, 默认 4 秒
+// Timeouts.Handshake 与上游代理建立隧道的超时, 包括 TLS、CONNECT 与 socks5 握手, 普通 http 请求为 TLS 握手的超时, 默认 10 秒
+// Timeouts.Request 普通 http 请求等待响应头的超时, 包括建立连接, 响应体流式转发不受限制, 默认 10 秒
+// Timeouts.Idle 隧道双向都没有数据传输超过该时长后关闭, 默认 5 分钟, 负数(如 -1s)表示不限制, 适合 WebSocket 等长连接
+type Timeouts struct {
+	Dial      time.Duration `json:"dial" yaml:"dial"`
+	Handshake time.Duration `json:"handshake" yaml:"handshake"`
+	Request   time.Duration `json:"request" yaml:"request"`
+	Idle      time.Duration `json:"idle" yaml:"idle"`
+}
+
+// MITM 解密 HTTPS, 使用配置的 CA 即时签发目标主机证书, 客户端需要信任该 CA
+// MITM.CACert MITM.CAKey CA 证书与私钥文件, PEM 格式
+// MITM.CacheSize 缓存的证书数量, 超出时淘汰最久未使用的证书, 默认 1000
+type MITM struct {
+	CACert    string `json:"caCert" yaml:"caCert"`
+	CAKey     string `json:"caKey" yaml:"caKey"`
+	CacheSize int    `json:"cacheSize" yaml:"cacheSize"`
+}
+
+// Breaker 地址熔断, 最近请求的失败率过高时暂停使用该地址, 冷却后放行一个请求试探
+// Breaker.Window 统计失败率的最近请求数量, 默认 10
+// Breaker.MinRequests 统计的请求数达到该值后才会熔断, 默认 3
+// Breaker.FailureRate 失败率达到该值时熔断, 默认 0.5
+// Breaker.Cooldown 熔断后的冷却时长, 试探失败时翻倍, 默认 30 秒
+// Breaker.MaxCooldown 冷却时长上限, 默认 10 分钟
+type Breaker struct {
+	Window      int           `json:"window" yaml:"window"`
+	MinRequests int           `json:"minRequests" yaml:"minRequests"`
+	FailureRate float64       `json:"failureRate" yaml:"failureRate"`
+	Cooldown    time.Duration `json:"cooldown" yaml:"cooldown"`
+	MaxCooldown time.Duration `json:"maxCooldown" yaml:"maxCooldown"`
+}
+
+// PoolConfig 命名代理池, 拥有独立的地址缓存、选择策略与后台预取
+// PoolConfig.Sources 使用的代理源名称, 代理源在 sources 中定义
+// PoolConfig.Strategy PoolConfig.HealthCheck 未配置时使用全局配置
+// PoolConfig.MinAddress PoolConfig.PrefetchRatio 仅对该代理池生效
+type PoolConfig struct {
+	Name          string       `json:"name" yaml:"name"`
+	Sources       []string     `json:"sources" yaml:"sources"`
+	Strategy      string       `json:"strategy" yaml:"strategy"`
+	MinAddress    int          `json:"minAddress" yaml:"minAddress"`
+	PrefetchRatio float64      `json:"prefetchRatio" yaml:"prefetchRatio"`
+	HealthCheck   *HealthCheck `json:"healthCheck" yaml:"healthCheck"`
 }
 
 // Config 配置
 // Config.PoolSize 池大小
+// Config.Strategy 地址选择策略 first|round-robin|random|lru|least-conn|weighted
+// Config.MinAddress 后台预取保持的最少可用地址数, 0 表示不预取
+// Config.PrefetchRatio 地址存活超过 ttl 的该比例后提前预取, 默认 0.8
+// Config.HealthCheck 健康检查, 不配置则不检查
+// Config.Auth 客户端认证, 不配置则不认证
+// Config.Rules 路由规则, 优先于 ProxyHost, 都未命中时直连
+// Config.Pools 命名代理池, 规则通过名称引用
+// Config.Session 会话保持, 不配置时使用默认值
+// Config.Retry 普通 http 请求的重试, 不配置时使用默认值
+// Config.Forwarded 转发头部的处理方式, 不配置时全部移除
+// Config.Timeouts 超时, 规则可单独覆盖
+// Config.MITM 解密 HTTPS 使用的 CA, 只对配置了 mitm 的规则生效
+// Config.Breaker 地址熔断, 不配置时使用默认值, 命名代理池共用
+// Config.BanTTL 地址被目标主机封禁的时长, 期间访问该主机时不再选择该地址, 默认 30 分钟
 type Config struct {
-	ProxyHost    []string       `json:"proxyHost" yaml:"host"`
-	ProxySources []*ProxySource `json:"proxySources" yaml:"sources"`
+	ProxyHost     []string       `json:"proxyHost" yaml:"host"`
+	ProxySources  []*ProxySource `json:"proxySources" yaml:"sources"`
+	Strategy      string         `json:"strategy" yaml:"strategy"`
+	MinAddress    int            `json:"minAddress" yaml:"minAddress"`
+	PrefetchRatio float64        `json:"prefetchRatio" yaml:"prefetchRatio"`
+	HealthCheck   *HealthCheck   `json:"healthCheck" yaml:"healthCheck"`
+	Auth          *Auth          `json:"auth" yaml:"auth"`
+	Rules         []*Rule        `json:"rules" yaml:"rules"`
+	Pools         []*PoolConfig  `json:"pools" yaml:"pools"`
+	Session       *Session       `json:"session" yaml:"session"`
+	Retry         *Retry         `json:"retry" yaml:"retry"`
+	Forwarded     *Forwarded     `json:"forwarded" yaml:"forwarded"`
+	Timeouts      *Timeouts      `json:"timeouts" yaml:"timeouts"`
+	MITM          *MITM          `json:"mitm" yaml:"mitm"`
+	Breaker       *Breaker       `json:"breaker" yaml:"breaker"`
+	BanTTL        time.Duration  `json:"banTTL" yaml:"banTTL"`
 }
 
 func ReadFromFile(path string) (*Config, error) {
@@ -39,20 +244,29 @@ func ReadFromFile(path string) (*Config, error) {
 		file.Close()
 		slog.Info("配置文件不存在，已创建")
 	}
-	// 打开文件
-	file, err := os.Open(path)
-	if err != nil {
-		log.Fatalf("无法打开文件: %v", err)
-	}
-	defer file.Close()
 	// 读取文件内容
-	byteValue, err := io.ReadAll(file)
+	byteValue, err := os.ReadFile(path)
 	if err != nil {
-		log.Fatalf("读取文件时出错: %v", err)
+		return nil, fmt.Errorf("读取文件时出错: %w", err)
 	}
+	return Parse(byteValue)
+}
+
+// Parse 解析配置内容
+func Parse(data []byte) (*Config, error) {
 	var c Config
-	if err := yaml.Unmarshal(byteValue, &c); err != nil {
-		log.Fatalf("解码 JSON 时出错: %v", err)
+	if err := yaml.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("解码配置时出错: %w", err)
+	}
+	names := make(map[string]bool, len(c.ProxySources))
+	for _, item := range c.ProxySources {
+		if item == nil || item.Name == "" {
+			continue
+		}
+		if names[item.Name] {
+			return nil, fmt.Errorf("代理源名称重复: %s", item.Name)
+		}
+		names[item.Name] = true
 	}
 	return &c, nil
 }
@@ -72,6 +286,8 @@ var LogEnabled bool
 var LogDirPath string
 var ConfigPath string
 var VersionOut bool
+var AdminAddr string
+var Socks5Addr string
 
 func AppArgsInit() {
 	flag.StringVar(&Host, "host", "0.0.0.0", "host")
@@ -81,6 +297,8 @@ func AppArgsInit() {
 	flag.BoolVar(&VersionOut, "version", false, "output version")
 	flag.StringVar(&LogDirPath, "logDir", "log", "log path")
 	flag.StringVar(&ConfigPath, "config", "conf.yaml", "config path")
+	flag.StringVar(&AdminAddr, "admin", "", "admin api listen address, e.g. 127.0.0.1:8002, empty to disable")
+	flag.StringVar(&Socks5Addr, "socks5", "", "socks5 listen address, e.g. 0.0.0.0:1080, empty to disable")
 	flag.Parse()
 	LogDirPath = checkPath(LogDirPath)
 	ConfigPath = checkPath(ConfigPath)
@@ -0,0 +1,89 @@
+package conf
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	t.Run("解析配置", func(t *testing.T) {
+		c, err := Parse([]byte(`
+strategy: weighted
+banTTL: 10m
+timeouts:
+  idle: -1s
+sources:
+  - name: a
+    type: fixed
+    fixedAddr: [127.0.0.1:8080]
+    ttl: 30s
+  - fetchURL: http://example.com/get
+  - fetchURL: http://example.com/get
+rules:
+  - domain: example.com
+    pool: named
+    fallback: fail
+`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Strategy != "weighted" || c.BanTTL != 10*time.Minute || c.Timeouts.Idle != -time.Second || len(c.ProxySources) != 3 {
+			t.Fatal(c)
+		}
+		if s := c.ProxySources[0]; s.Name != "a" || s.TTL != 30*time.Second || len(s.FixedAddr) != 1 {
+			t.Fatal(s)
+		}
+		if r := c.Rules[0]; r.Domain != "example.com" || r.Pool != "named" || r.Fallback != "fail" {
+			t.Fatal(r)
+		}
+	})
+	t.Run("内容无效", func(t *testing.T) {
+		if _, err := Parse([]byte("sources: [")); err == nil {
+			t.Fatal("should fail")
+		}
+	})
+	t.Run("代理源名称重复", func(t *testing.T) {
+		if _, err := Parse([]byte("sources:\n  - name: a\n  - name: a\n")); err == nil {
+			t.Fatal("should fail")
+		}
+	})
+}
+
+func TestWatcher(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(path, []byte("strategy: first\n"), 0600)
+	var changes []*Config
+	w := NewWatcher(path, func(c *Config) {
+		changes = append(changes, c)
+	})
+	t.Run("内容未变化时跳过", func(t *testing.T) {
+		w.reload(false)
+		if len(changes) != 0 {
+			t.Fatal(len(changes))
+		}
+	})
+	t.Run("内容变化时回调", func(t *testing.T) {
+		os.WriteFile(path, []byte("strategy: random\n"), 0600)
+		w.reload(false)
+		if len(changes) != 1 || changes[0].Strategy != "random" {
+			t.Fatal(changes)
+		}
+	})
+	t.Run("配置无效时保留当前配置", func(t *testing.T) {
+		os.WriteFile(path, []byte("sources:\n  - name: a\n  - name: a\n"), 0600)
+		w.reload(false)
+		if len(changes) != 1 {
+			t.Fatal(len(changes))
+		}
+	})
+	t.Run("强制重新加载", func(t *testing.T) {
+		os.WriteFile(path, []byte("strategy: random\n"), 0600)
+		w.reload(false)
+		w.reload(true)
+		if len(changes) != 3 {
+			t.Fatal(len(changes))
+		}
+	})
+}
@@ -0,0 +1,86 @@
+package conf
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// watchInterval 配置文件检查间隔
+const watchInterval = 2 * time.Second
+
+// Watcher 监听配置文件变化与 SIGHUP 信号, 配置有效时回调 OnChange
+// 配置解析失败时保留当前配置
+type Watcher struct {
+	Path     string
+	OnChange func(*Config)
+	last     []byte
+	done     chan struct{}
+	once     sync.Once
+}
+
+func NewWatcher(path string, onChange func(*Config)) *Watcher {
+	last, _ := os.ReadFile(path)
+	return &Watcher{
+		Path:     path,
+		OnChange: onChange,
+		last:     last,
+		done:     make(chan struct{}),
+	}
+}
+
+// Start 启动监听
+func (w *Watcher) Start() {
+	go w.loop()
+}
+
+// Close 停止监听
+func (w *Watcher) Close() {
+	w.once.Do(func() {
+		close(w.done)
+	})
+}
+
+func (w *Watcher) loop() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-hup:
+			slog.Info("收到 SIGHUP, 重新加载配置")
+			w.reload(true)
+		case <-ticker.C:
+			w.reload(false)
+		}
+	}
+}
+
+// reload 读取配置文件, 内容未变化且非强制时跳过
+func (w *Watcher) reload(force bool) {
+	data, err := os.ReadFile(w.Path)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("读取配置文件失败: %s", err))
+		return
+	}
+	if !force && bytes.Equal(data, w.last) {
+		return
+	}
+	w.last = data
+	c, err := Parse(data)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("配置文件无效, 继续使用当前配置: %s", err))
+		return
+	}
+	slog.Info("配置已重新加载")
+	w.OnChange(c)
+}
@@ -0,0 +1,258 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 手写的 Prometheus 文本格式指标, 避免引入额外依赖
+// https://prometheus.io/docs/instrumenting/exposition_formats/
+
+// collector 可输出为文本格式的指标
+type collector interface {
+	metricName() string
+	write(w io.Writer)
+}
+
+// Registry 指标注册表
+type Registry struct {
+	mu         sync.Mutex
+	collectors []collector
+}
+
+// DefaultRegistry 默认注册表
+var DefaultRegistry = &Registry{}
+
+// register 注册指标, 同名指标会被替换
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, item := range r.collectors {
+		if item.metricName() == c.metricName() {
+			r.collectors[i] = c
+			return
+		}
+	}
+	r.collectors = append(r.collectors, c)
+}
+
+// Write 按注册顺序输出全部指标
+func (r *Registry) Write(w io.Writer) {
+	r.mu.Lock()
+	collectors := make([]collector, len(r.collectors))
+	copy(collectors, r.collectors)
+	r.mu.Unlock()
+	for _, c := range collectors {
+		c.write(w)
+	}
+}
+
+// Handler 指标接口
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		DefaultRegistry.Write(w)
+	})
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatLabels 输出 {a="1",b="2"}, 没有标签时输出空字符串
+func formatLabels(names []string, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf(`%s="%s"`, name, labelEscaper.Replace(values[i]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func writeHeader(w io.Writer, name string, help string, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// labelKey 标签值拼接为 map 键
+func labelKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// checkLabels 标签值数量必须与标签名一致
+func checkLabels(name string, names []string, values []string) {
+	if len(names) != len(values) {
+		panic(fmt.Sprintf("metric %s: expected %d label values, got %d", name, len(names), len(values)))
+	}
+}
+
+// CounterVec 带标签的计数器
+type CounterVec struct {
+	name   string
+	help   string
+	labels []string
+	mu     sync.Mutex
+	values map[string]*counterValue
+}
+
+type counterValue struct {
+	labels []string
+	value  float64
+}
+
+func NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	c := &CounterVec{name: name, help: help, labels: labels, values: make(map[string]*counterValue)}
+	DefaultRegistry.register(c)
+	return c
+}
+
+func (c *CounterVec) metricName() string {
+	return c.name
+}
+
+// Add 增加计数, v 必须非负
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	checkLabels(c.name, c.labels, labelValues)
+	key := labelKey(labelValues)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	item, ok := c.values[key]
+	if !ok {
+		item = &counterValue{labels: append([]string(nil), labelValues...)}
+		c.values[key] = item
+	}
+	item.value += v
+}
+
+// Inc 计数加一
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	writeHeader(w, c.name, c.help, "counter")
+	for _, key := range sortedKeys(c.values) {
+		item := c.values[key]
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, item.labels), formatFloat(item.value))
+	}
+}
+
+// DefaultBuckets 默认的耗时分桶, 单位秒
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// HistogramVec 带标签的直方图
+type HistogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+	mu      sync.Mutex
+	values  map[string]*histogramValue
+}
+
+type histogramValue struct {
+	labels []string
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// NewHistogramVec buckets 为空时使用 DefaultBuckets
+func NewHistogramVec(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	h := &HistogramVec{name: name, help: help, labels: labels, buckets: buckets, values: make(map[string]*histogramValue)}
+	DefaultRegistry.register(h)
+	return h
+}
+
+func (h *HistogramVec) metricName() string {
+	return h.name
+}
+
+// Observe 记录一个观测值
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	checkLabels(h.name, h.labels, labelValues)
+	key := labelKey(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	item, ok := h.values[key]
+	if !ok {
+		item = &histogramValue{labels: append([]string(nil), labelValues...), counts: make([]uint64, len(h.buckets))}
+		h.values[key] = item
+	}
+	for i, bound := range h.buckets {
+		if v <= bound {
+			item.counts[i]++
+		}
+	}
+	item.sum += v
+	item.count++
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	writeHeader(w, h.name, h.help, "histogram")
+	names := append(append([]string(nil), h.labels...), "le")
+	for _, key := range sortedKeys(h.values) {
+		item := h.values[key]
+		for i, bound := range h.buckets {
+			values := append(append([]string(nil), item.labels...), formatFloat(bound))
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(names, values), item.counts[i])
+		}
+		values := append(append([]string(nil), item.labels...), "+Inf")
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(names, values), item.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, item.labels), formatFloat(item.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, item.labels), item.count)
+	}
+}
+
+// GaugeFunc 采集时调用 fn 取值的仪表
+type GaugeFunc struct {
+	name string
+	help string
+	fn   func() float64
+}
+
+func NewGaugeFunc(name string, help string, fn func() float64) *GaugeFunc {
+	g := &GaugeFunc{name: name, help: help, fn: fn}
+	DefaultRegistry.register(g)
+	return g
+}
+
+func (g *GaugeFunc) metricName() string {
+	return g.name
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	writeHeader(w, g.name, g.help, "gauge")
+	fmt.Fprintf(w, "%s %s\n", g.name, formatFloat(g.fn()))
+}
+
+// sortedKeys 排序后输出, 保证结果稳定
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
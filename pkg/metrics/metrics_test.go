@@ -0,0 +1,35 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestExposition(t *testing.T) {
+	t.Run("文本格式输出", func(t *testing.T) {
+		c := NewCounterVec("test_requests_total", "Test requests.", "mode")
+		c.Inc("connect")
+		c.Add(2, `a"b`)
+		h := NewHistogramVec("test_latency_seconds", "Test latency.", []float64{0.1, 1})
+		h.Observe(0.5)
+		var buf bytes.Buffer
+		DefaultRegistry.Write(&buf)
+		text := buf.String()
+		for _, line := range []string{
+			"# TYPE test_requests_total counter",
+			`test_requests_total{mode="connect"} 1`,
+			`test_requests_total{mode="a\"b"} 2`,
+			"# TYPE test_latency_seconds histogram",
+			`test_latency_seconds_bucket{le="0.1"} 0`,
+			`test_latency_seconds_bucket{le="1"} 1`,
+			`test_latency_seconds_bucket{le="+Inf"} 1`,
+			"test_latency_seconds_sum 0.5",
+			"test_latency_seconds_count 1",
+		} {
+			if !strings.Contains(text, line+"\n") {
+				t.Fatalf("missing %q in:\n%s", line, text)
+			}
+		}
+	})
+}
@@ -80,6 +80,13 @@ func RequestID(next http.Handler) http.Handler {
 	return http.HandlerFunc(fn)
 }
 
+// WithRequestID returns a copy of ctx carrying a newly generated request ID,
+// for connections that are not served through the RequestID middleware.
+func WithRequestID(ctx context.Context) context.Context {
+	requestID := fmt.Sprintf("%s-%06d", prefix, NextRequestID())
+	return context.WithValue(ctx, RequestIDKey, requestID)
+}
+
 // GetReqID returns a request ID from the given context if one is present.
 // Returns the empty string if a request ID cannot be found.
 func GetReqID(ctx context.Context) string {
@@ -0,0 +1,189 @@
+package mitm
+
+import (
+	"container/list"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"easy-http-proxy-pool/pkg/conf"
+	"fmt"
+	"math/big"
+	"net"
+	"sync"
+	"time"
+)
+
+// 签发证书的默认配置
+const (
+	defaultCacheSize = 1000
+	leafValidity     = 365 * 24 * time.Hour
+	// leafBackdate 证书生效时间提前, 兼容时钟略有偏差的客户端
+	leafBackdate = time.Hour
+)
+
+// Authority 使用配置的 CA 为目标主机签发证书, 配置加载或重新加载时构建
+// Authority.key 所有证书共用的私钥, 避免每次签发都生成密钥
+// Authority.lru 队首为最近使用的证书
+type Authority struct {
+	ca      *x509.Certificate
+	caKey   crypto.Signer
+	key     *ecdsa.PrivateKey
+	mu      sync.Mutex
+	size    int
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+// cacheEntry 缓存的证书
+type cacheEntry struct {
+	host string
+	cert *tls.Certificate
+}
+
+// New 加载 CA 证书与私钥, 未配置时返回 nil 表示不解密
+func New(c *conf.MITM) (*Authority, error) {
+	if c == nil {
+		return nil, nil
+	}
+	pair, err := tls.LoadX509KeyPair(c.CACert, c.CAKey)
+	if err != nil {
+		return nil, fmt.Errorf("加载 CA 证书失败: %w", err)
+	}
+	ca, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("解析 CA 证书失败: %w", err)
+	}
+	if !ca.IsCA {
+		return nil, fmt.Errorf("证书不是 CA 证书: %s", c.CACert)
+	}
+	caKey, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("不支持的 CA 私钥类型: %T", pair.PrivateKey)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	size := defaultCacheSize
+	if c.CacheSize > 0 {
+		size = c.CacheSize
+	}
+	return &Authority{
+		ca:      ca,
+		caKey:   caKey,
+		key:     key,
+		size:    size,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}, nil
+}
+
+// Certificate 返回主机对应的证书, 未缓存或已过期时重新签发
+func (a *Authority) Certificate(host string) (*tls.Certificate, error) {
+	if cert, ok := a.get(host); ok {
+		return cert, nil
+	}
+	cert, err := a.sign(host)
+	if err != nil {
+		return nil, err
+	}
+	a.put(host, cert)
+	return cert, nil
+}
+
+// TLSConfig 终止客户端 TLS 的配置, 客户端未发送 SNI 时使用 host
+func (a *Authority) TLSConfig(host string) *tls.Config {
+	return &tls.Config{
+		NextProtos: []string{"http/1.1"},
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if hello.ServerName != "" {
+				return a.Certificate(hello.ServerName)
+			}
+			return a.Certificate(host)
+		},
+	}
+}
+
+// Size 缓存的证书数量
+func (a *Authority) Size() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.lru.Len()
+}
+
+func (a *Authority) get(host string) (*tls.Certificate, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	elem, ok := a.entries[host]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	if !entry.cert.Leaf.NotAfter.After(time.Now()) {
+		a.lru.Remove(elem)
+		delete(a.entries, host)
+		return nil, false
+	}
+	a.lru.MoveToFront(elem)
+	return entry.cert, true
+}
+
+// put 缓存证书, 超过数量限制时淘汰最久未使用的证书
+func (a *Authority) put(host string, cert *tls.Certificate) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if elem, ok := a.entries[host]; ok {
+		elem.Value.(*cacheEntry).cert = cert
+		a.lru.MoveToFront(elem)
+		return
+	}
+	a.entries[host] = a.lru.PushFront(&cacheEntry{host: host, cert: cert})
+	for a.lru.Len() > a.size {
+		elem := a.lru.Back()
+		a.lru.Remove(elem)
+		delete(a.entries, elem.Value.(*cacheEntry).host)
+	}
+}
+
+// sign 签发主机证书, 有效期不超过 CA 证书
+func (a *Authority) sign(host string) (*tls.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	notAfter := now.Add(leafValidity)
+	if notAfter.After(a.ca.NotAfter) {
+		notAfter = a.ca.NotAfter
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: host},
+		NotBefore:    now.Add(-leafBackdate),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{host}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, a.ca, &a.key.PublicKey, a.caKey)
+	if err != nil {
+		return nil, fmt.Errorf("签发证书失败 %s: %w", host, err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{
+		Certificate: [][]byte{der, a.ca.Raw},
+		PrivateKey:  a.key,
+		Leaf:        leaf,
+	}, nil
+}
@@ -0,0 +1,84 @@
+package mitm
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"easy-http-proxy-pool/pkg/conf"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCA 生成测试用 CA 并写入临时目录
+func writeCA(t *testing.T) (*conf.MITM, *x509.Certificate) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, _ := x509.ParseCertificate(der)
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+	dir := t.TempDir()
+	c := &conf.MITM{CACert: filepath.Join(dir, "ca.crt"), CAKey: filepath.Join(dir, "ca.key"), CacheSize: 2}
+	os.WriteFile(c.CACert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(c.CAKey, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	return c, ca
+}
+
+func TestAuthority(t *testing.T) {
+	c, ca := writeCA(t)
+	a, err := New(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Run("签发的证书由 CA 验证通过", func(t *testing.T) {
+		roots := x509.NewCertPool()
+		roots.AddCert(ca)
+		for _, host := range []string{"example.com", "127.0.0.1"} {
+			cert, err := a.Certificate(host)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := cert.Leaf.Verify(x509.VerifyOptions{DNSName: host, Roots: roots}); err != nil {
+				t.Fatal(host, err)
+			}
+			if cert.Leaf.NotAfter.After(ca.NotAfter) {
+				t.Fatal("leaf outlives ca")
+			}
+		}
+	})
+	t.Run("缓存证书并淘汰最久未使用的证书", func(t *testing.T) {
+		first, _ := a.Certificate("a.com")
+		if again, _ := a.Certificate("a.com"); again != first {
+			t.Fatal("certificate should be cached")
+		}
+		a.Certificate("b.com")
+		a.Certificate("c.com")
+		if a.Size() != 2 {
+			t.Fatal(a.Size())
+		}
+		if again, _ := a.Certificate("a.com"); again == first {
+			t.Fatal("certificate should be evicted")
+		}
+	})
+	t.Run("未配置时不解密", func(t *testing.T) {
+		if a, err := New(nil); a != nil || err != nil {
+			t.Fatal(a, err)
+		}
+	})
+}
@@ -0,0 +1,87 @@
+package pool
+
+import (
+	"net"
+	"slices"
+	"strings"
+	"time"
+)
+
+// defaultBanTTL 地址被目标主机封禁的默认时长
+const defaultBanTTL = 30 * time.Minute
+
+// banHost 封禁记录使用的主机名, 去掉端口并转为小写, 同一主机的不同端口共用封禁
+func banHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(strings.Trim(host, "[]"))
+}
+
+// banList 地址被目标主机封禁的记录, 由所属代理池的 mu 保护
+// 与地址缓存分开保存, 地址过期后再次提取到时封禁仍然有效
+// banList.entries 地址 -> 主机名 -> 解封时间
+type banList struct {
+	ttl     time.Duration
+	entries map[string]map[string]time.Time
+}
+
+func newBanList() *banList {
+	return &banList{ttl: defaultBanTTL, entries: make(map[string]map[string]time.Time)}
+}
+
+// configure 应用配置, 不大于 0 时使用默认值, 只影响之后的封禁
+func (b *banList) configure(ttl time.Duration) {
+	if ttl <= 0 {
+		ttl = defaultBanTTL
+	}
+	b.ttl = ttl
+}
+
+// ban 封禁地址访问主机, 返回解封时间, 同时清理已解封的记录
+func (b *banList) ban(addr string, host string, now time.Time) time.Time {
+	b.prune(now)
+	hosts, ok := b.entries[addr]
+	if !ok {
+		hosts = make(map[string]time.Time)
+		b.entries[addr] = hosts
+	}
+	until := now.Add(b.ttl)
+	hosts[host] = until
+	return until
+}
+
+// banned 地址是否被主机封禁, host 为空时不检查
+func (b *banList) banned(addr string, host string, now time.Time) bool {
+	if host == "" {
+		return false
+	}
+	until, ok := b.entries[addr][host]
+	return ok && until.After(now)
+}
+
+// hosts 封禁了地址且尚未解封的主机名
+func (b *banList) hosts(addr string, now time.Time) []string {
+	var result []string
+	for host, until := range b.entries[addr] {
+		if until.After(now) {
+			result = append(result, host)
+		}
+	}
+	slices.Sort(result)
+	return result
+}
+
+// prune 清理已解封的记录
+func (b *banList) prune(now time.Time) {
+	for addr, hosts := range b.entries {
+		for host, until := range hosts {
+			if !until.After(now) {
+				delete(hosts, host)
+			}
+		}
+		if len(hosts) == 0 {
+			delete(b.entries, addr)
+		}
+	}
+}
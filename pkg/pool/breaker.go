@@ -0,0 +1,186 @@
+package pool
+
+import (
+	"easy-http-proxy-pool/pkg/conf"
+	"time"
+)
+
+// 熔断默认配置
+const (
+	defaultBreakerWindow      = 10
+	defaultBreakerMinRequests = 3
+	defaultBreakerFailureRate = 0.5
+	defaultBreakerCooldown    = 30 * time.Second
+	defaultBreakerMaxCooldown = 10 * time.Minute
+)
+
+// 熔断状态
+// BreakerClosed 正常使用
+// BreakerOpen 熔断中, 冷却结束前不会被选中
+// BreakerHalfOpen 冷却结束, 同一时间只放行一个请求试探, 成功后恢复, 失败后再次熔断
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+// breakerConfig 合并默认值后的熔断配置
+type breakerConfig struct {
+	window      int
+	minRequests int
+	failureRate float64
+	cooldown    time.Duration
+	maxCooldown time.Duration
+}
+
+func newBreakerConfig(c *conf.Breaker) breakerConfig {
+	b := breakerConfig{
+		window:      defaultBreakerWindow,
+		minRequests: defaultBreakerMinRequests,
+		failureRate: defaultBreakerFailureRate,
+		cooldown:    defaultBreakerCooldown,
+		maxCooldown: defaultBreakerMaxCooldown,
+	}
+	if c == nil {
+		return b
+	}
+	if c.Window > 0 {
+		b.window = c.Window
+	}
+	if c.MinRequests > 0 {
+		b.minRequests = c.MinRequests
+	}
+	if c.FailureRate > 0 && c.FailureRate <= 1 {
+		b.failureRate = c.FailureRate
+	}
+	if c.Cooldown > 0 {
+		b.cooldown = c.Cooldown
+	}
+	if c.MaxCooldown > 0 {
+		b.maxCooldown = c.MaxCooldown
+	}
+	if b.minRequests > b.window {
+		b.minRequests = b.window
+	}
+	if b.maxCooldown < b.cooldown {
+		b.maxCooldown = b.cooldown
+	}
+	return b
+}
+
+// breaker 单个地址的熔断器, 由所属代理池的 mu 保护
+// breaker.results 最近的请求结果, true 表示失败, 按 next 循环写入
+// breaker.cooldown 本次熔断的冷却时长, 连续熔断时翻倍
+// breaker.probing 半开状态下试探请求进行中
+type breaker struct {
+	results   []bool
+	next      int
+	count     int
+	openUntil time.Time
+	cooldown  time.Duration
+	probing   bool
+}
+
+// state 当前状态, 冷却结束后视为半开
+func (b *breaker) state(now time.Time) string {
+	if b.openUntil.IsZero() {
+		return BreakerClosed
+	}
+	if b.openUntil.After(now) {
+		return BreakerOpen
+	}
+	return BreakerHalfOpen
+}
+
+// allow 地址是否可以被选中
+func (b *breaker) allow(now time.Time) bool {
+	switch b.state(now) {
+	case BreakerOpen:
+		return false
+	case BreakerHalfOpen:
+		return !b.probing
+	}
+	return true
+}
+
+// take 地址被选中, 半开状态下作为试探请求
+func (b *breaker) take(now time.Time) {
+	if b.state(now) == BreakerHalfOpen {
+		b.probing = true
+	}
+}
+
+// release 地址被归还, active 为归还后的占用数
+// 试探期间不会再有新的占用, 占用全部归还说明试探请求未报告结果就已归还, 允许下一个请求试探
+// 熔断前获取地址的请求先归还时不能清除试探状态
+func (b *breaker) release(active int) {
+	if active == 0 {
+		b.probing = false
+	}
+}
+
+// record 记录请求结果, 返回是否因此熔断
+// 熔断期间的结果来自熔断前获取地址的请求, 直接忽略, 避免冷却时长被连续翻倍或提前恢复
+// 半开状态只有试探进行中的结果才视为试探结果
+func (b *breaker) record(c breakerConfig, failed bool, now time.Time) bool {
+	switch b.state(now) {
+	case BreakerOpen:
+		return false
+	case BreakerHalfOpen:
+		if !b.probing {
+			return false
+		}
+		b.probing = false
+		if failed {
+			b.open(c, now, b.cooldown*2)
+			return true
+		}
+		// 试探成功, 恢复正常
+		b.reset()
+		b.openUntil = time.Time{}
+		b.cooldown = 0
+		return false
+	}
+	if len(b.results) != c.window {
+		b.results = make([]bool, c.window)
+		b.next, b.count = 0, 0
+	}
+	b.results[b.next] = failed
+	b.next = (b.next + 1) % len(b.results)
+	if b.count < len(b.results) {
+		b.count++
+	}
+	if !failed || b.count < c.minRequests {
+		return false
+	}
+	failures := 0
+	for i := 0; i < b.count; i++ {
+		if b.results[i] {
+			failures++
+		}
+	}
+	if float64(failures)/float64(b.count) < c.failureRate {
+		return false
+	}
+	b.open(c, now, c.cooldown)
+	return true
+}
+
+// open 熔断 cooldown 时长, 不超过配置的上限
+func (b *breaker) open(c breakerConfig, now time.Time, cooldown time.Duration) {
+	if cooldown < c.cooldown {
+		cooldown = c.cooldown
+	}
+	if cooldown > c.maxCooldown {
+		cooldown = c.maxCooldown
+	}
+	b.cooldown = cooldown
+	b.openUntil = now.Add(cooldown)
+	b.reset()
+}
+
+// reset 清空统计窗口
+func (b *breaker) reset() {
+	clear(b.results)
+	b.next, b.count = 0, 0
+}
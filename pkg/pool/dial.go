@@ -0,0 +1,32 @@
+package pool
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/url"
+	"time"
+)
+
+// DialProxy 连接代理地址, https 协议完成 TLS 握手
+// dialTimeout 为 tcp 连接超时, handshakeTimeout 为返回的连接设置的读写截止时间, 覆盖 TLS 握手与之后的隧道握手
+// 超时不大于 0 时只受 ctx 限制, 设置了截止时间的连接在隧道建立后由调用方清除
+func DialProxy(ctx context.Context, proxyURL *url.URL, dialTimeout time.Duration, handshakeTimeout time.Duration) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: dialTimeout, KeepAlive: 15 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", proxyURL.Host)
+	if err != nil {
+		return nil, err
+	}
+	if handshakeTimeout > 0 {
+		conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	}
+	if proxyURL.Scheme != ProtocolHTTPS {
+		return conn, nil
+	}
+	tlsConn := tls.Client(conn, &tls.Config{ServerName: proxyURL.Hostname(), InsecureSkipVerify: true})
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
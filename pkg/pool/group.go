@@ -0,0 +1,187 @@
+package pool
+
+import (
+	"easy-http-proxy-pool/pkg/conf"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+)
+
+// Group 默认代理池与命名代理池
+// 默认代理池使用全部代理源, 命名代理池只使用配置的代理源, 各自缓存地址互不影响
+// Group.mu 保护命名代理池列表, 重新加载时新增或移除代理池
+// Group.onRemove 所有代理池共用的地址移除回调
+type Group struct {
+	mu       sync.RWMutex
+	def      *DynamicPool
+	named    map[string]*DynamicPool
+	started  bool
+	onRemove func(addr string)
+}
+
+// namedConfigs 为每个命名代理池生成独立的配置, 代理源或名称无效时返回错误
+func namedConfigs(config *conf.Config) (map[string]*conf.Config, error) {
+	sources := make(map[string]*conf.ProxySource, len(config.ProxySources))
+	for _, item := range config.ProxySources {
+		sources[item.Name] = item
+	}
+	result := make(map[string]*conf.Config, len(config.Pools))
+	for _, item := range config.Pools {
+		if item.Name == "" {
+			return nil, fmt.Errorf("代理池缺少名称")
+		}
+		if _, ok := result[item.Name]; ok {
+			return nil, fmt.Errorf("代理池名称重复: %s", item.Name)
+		}
+		if len(item.Sources) == 0 {
+			return nil, fmt.Errorf("代理池 %s 未配置代理源", item.Name)
+		}
+		c := &conf.Config{
+			Strategy:      item.Strategy,
+			MinAddress:    item.MinAddress,
+			PrefetchRatio: item.PrefetchRatio,
+			HealthCheck:   item.HealthCheck,
+			Session:       config.Session,
+			Breaker:       config.Breaker,
+			BanTTL:        config.BanTTL,
+		}
+		if c.Strategy == "" {
+			c.Strategy = config.Strategy
+		}
+		if c.HealthCheck == nil {
+			c.HealthCheck = config.HealthCheck
+		}
+		for _, name := range item.Sources {
+			source, ok := sources[name]
+			if !ok {
+				return nil, fmt.Errorf("代理池 %s 的代理源不存在: %s", item.Name, name)
+			}
+			c.ProxySources = append(c.ProxySources, source)
+		}
+		result[item.Name] = c
+	}
+	return result, nil
+}
+
+func NewGroup(config *conf.Config) (*Group, error) {
+	configs, err := namedConfigs(config)
+	if err != nil {
+		return nil, err
+	}
+	g := &Group{
+		def:   NewDynamicPool(config),
+		named: make(map[string]*DynamicPool, len(configs)),
+	}
+	for name, c := range configs {
+		g.named[name] = NewDynamicPool(c)
+	}
+	return g, nil
+}
+
+// Default 默认代理池
+func (g *Group) Default() *DynamicPool {
+	return g.def
+}
+
+// Get 按名称获取代理池, 名称为空时返回默认代理池
+func (g *Group) Get(name string) (*DynamicPool, bool) {
+	if name == "" {
+		return g.def, true
+	}
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	p, ok := g.named[name]
+	return p, ok
+}
+
+// Names 命名代理池名称, 按名称排序
+func (g *Group) Names() []string {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	names := make([]string, 0, len(g.named))
+	for name := range g.named {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Size 所有代理池中未过期的地址总数
+func (g *Group) Size() int {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	size := g.def.Size()
+	for _, p := range g.named {
+		size += p.Size()
+	}
+	return size
+}
+
+// OnRemove 为所有代理池设置地址移除回调, 之后重新加载时新增的代理池同样生效
+// 同一地址可能同时存在于多个代理池, 任一代理池移除该地址都会回调
+func (g *Group) OnRemove(fn func(addr string)) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.onRemove = fn
+	g.def.OnRemove(fn)
+	for _, p := range g.named {
+		p.OnRemove(fn)
+	}
+}
+
+// Start 启动所有代理池的后台任务, 之后重新加载时新增的代理池会自动启动
+func (g *Group) Start() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.started = true
+	g.def.Start()
+	for _, p := range g.named {
+		p.Start()
+	}
+}
+
+// Close 停止所有代理池的后台任务
+func (g *Group) Close() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.def.Close()
+	for _, p := range g.named {
+		p.Close()
+	}
+}
+
+// Reload 重新加载配置, 同名代理池按 DynamicPool.Reload 保留地址, 已移除的代理池停止后台任务
+// 配置无效时不做任何修改
+func (g *Group) Reload(config *conf.Config) error {
+	configs, err := namedConfigs(config)
+	if err != nil {
+		return err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.def.Reload(config)
+	named := make(map[string]*DynamicPool, len(configs))
+	for name, c := range configs {
+		if p, ok := g.named[name]; ok {
+			p.Reload(c)
+			named[name] = p
+			continue
+		}
+		p := NewDynamicPool(c)
+		p.OnRemove(g.onRemove)
+		if g.started {
+			p.Start()
+		}
+		named[name] = p
+		slog.Info(fmt.Sprintf("新增代理池 %s", name))
+	}
+	for name, p := range g.named {
+		if _, ok := named[name]; !ok {
+			p.Close()
+			slog.Info(fmt.Sprintf("移除代理池 %s", name))
+		}
+	}
+	g.named = named
+	return nil
+}
@@ -0,0 +1,236 @@
+package pool
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"easy-http-proxy-pool/pkg/conf"
+	"easy-http-proxy-pool/pkg/socks5"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// 健康检查方式
+const (
+	HealthCheckTCP     = "tcp"
+	HealthCheckConnect = "connect"
+	HealthCheckGet     = "get"
+)
+
+const (
+	defaultHealthInterval = 30 * time.Second
+	defaultHealthTimeout  = 5 * time.Second
+	defaultConnectTarget  = "www.baidu.com:443"
+	defaultGetTarget      = "http://www.baidu.com"
+	// healthConcurrency 同时进行的检查数量
+	healthConcurrency = 16
+)
+
+// probeResult 单个地址的检查结果
+type probeResult struct {
+	addr    string
+	latency time.Duration
+	err     error
+}
+
+// probeTCP 仅检查代理端口能否连通
+func probeTCP(ctx context.Context, proxyURL *url.URL) error {
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", proxyURL.Host)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// probeConnect 通过代理建立到 target 的隧道, http 代理期望 CONNECT 返回 2xx
+func probeConnect(ctx context.Context, proxyURL *url.URL, target string) error {
+	conn, err := DialProxy(ctx, proxyURL, 0, 0)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	switch proxyURL.Scheme {
+	case ProtocolSocks5, ProtocolSocks5H:
+		if proxyURL.Scheme == ProtocolSocks5 {
+			if target, err = socks5.ResolveTarget(ctx, target); err != nil {
+				return err
+			}
+		}
+		return socks5.ClientHandshake(conn, target, proxyURL.User)
+	}
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: target},
+		Host:   target,
+		Header: make(http.Header),
+	}
+	if proxyURL.User != nil {
+		req.Header.Set("Proxy-Authorization", ProxyAuthorization(proxyURL.User))
+	}
+	if err := req.Write(conn); err != nil {
+		return err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("CONNECT %s: %s", target, resp.Status)
+	}
+	return nil
+}
+
+// probeGet 通过代理完整请求一次 target, 期望返回 2xx
+func probeGet(ctx context.Context, proxyURL *url.URL, target string) error {
+	tr := &http.Transport{
+		Proxy:             http.ProxyURL(proxyURL),
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+		DisableKeepAlives: true,
+	}
+	defer tr.CloseIdleConnections()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := (&http.Client{Transport: tr}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("GET %s: %s", target, resp.Status)
+	}
+	return nil
+}
+
+// probe 按配置检查一个地址并记录耗时
+func probe(hc *conf.HealthCheck, addr string) probeResult {
+	timeout := hc.Timeout
+	if timeout <= 0 {
+		timeout = defaultHealthTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	start := time.Now()
+	proxyURL, err := ParseAddress(addr)
+	if err != nil {
+		return probeResult{addr: addr, err: err}
+	}
+	switch hc.Type {
+	case HealthCheckConnect:
+		target := hc.Target
+		if target == "" {
+			target = defaultConnectTarget
+		}
+		err = probeConnect(ctx, proxyURL, target)
+	case HealthCheckGet:
+		target := hc.Target
+		if target == "" {
+			target = defaultGetTarget
+		}
+		err = probeGet(ctx, proxyURL, target)
+	default:
+		err = probeTCP(ctx, proxyURL)
+	}
+	return probeResult{addr: addr, latency: time.Since(start), err: err}
+}
+
+// healthLoop 定时检查缓存中的地址, 每秒读取一次配置以支持重新加载
+func (r *DynamicPool) healthLoop() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	var lastCheck time.Time
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+		}
+		r.mu.Lock()
+		hc := r.healthCheck
+		r.mu.Unlock()
+		if hc == nil {
+			continue
+		}
+		interval := hc.Interval
+		if interval <= 0 {
+			interval = defaultHealthInterval
+		}
+		if time.Since(lastCheck) < interval {
+			continue
+		}
+		r.checkHealth()
+		lastCheck = time.Now()
+	}
+}
+
+// checkHealth 检查所有未过期的地址, 检查期间不持有锁
+func (r *DynamicPool) checkHealth() {
+	r.mu.Lock()
+	hc := r.healthCheck
+	if hc == nil {
+		r.mu.Unlock()
+		return
+	}
+	r.removeExpired()
+	addrs := make([]string, len(r.addrStore))
+	for i, item := range r.addrStore {
+		addrs[i] = item.addr
+	}
+	r.mu.Unlock()
+
+	results := make([]probeResult, len(addrs))
+	sem := make(chan struct{}, healthConcurrency)
+	var wg sync.WaitGroup
+	for i, addr := range addrs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			results[i] = probe(hc, addr)
+			<-sem
+		}()
+	}
+	wg.Wait()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, result := range results {
+		r.applyProbeResult(hc, result)
+	}
+}
+
+// applyProbeResult 记录检查结果, 失败的地址隔离或移除
+func (r *DynamicPool) applyProbeResult(hc *conf.HealthCheck, result probeResult) {
+	for i, item := range r.addrStore {
+		if item.addr != result.addr {
+			continue
+		}
+		if result.err == nil {
+			item.latency = result.latency
+			item.quarantinedUntil = time.Time{}
+			slog.Debug(fmt.Sprintf("健康检查通过 %s", RedactAddress(item.addr)), slog.Duration("latency", result.latency))
+			return
+		}
+		if hc.Quarantine > 0 {
+			item.quarantinedUntil = time.Now().Add(hc.Quarantine)
+			slog.Info(fmt.Sprintf("健康检查失败, 已隔离 %s: %s", RedactAddress(item.addr), result.err))
+		} else {
+			r.addrStore = append(r.addrStore[:i], r.addrStore[i+1:]...)
+			r.removed(item.addr)
+			slog.Info(fmt.Sprintf("健康检查失败, 已移除 %s: %s", RedactAddress(item.addr), result.err))
+		}
+		return
+	}
+}
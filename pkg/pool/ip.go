@@ -1,16 +1,35 @@
 package pool
 
 import (
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"regexp"
 	"strings"
+	"time"
 )
 
+// Address 代理源加载到的地址
+// Address.ExpireAt 地址自带的过期时间, 零值时使用 ProxySource.TTL
+type Address struct {
+	Addr     string
+	ExpireAt time.Time
+}
+
 type Loader interface {
-	GetAddress() ([]string, error)
+	GetAddress() ([]Address, error)
+}
+
+// toAddresses 将不带过期时间的地址列表转换为 Address
+func toAddresses(addrs []string) []Address {
+	result := make([]Address, len(addrs))
+	for i, addr := range addrs {
+		result[i] = Address{Addr: addr}
+	}
+	return result
 }
 
 type CommonIpLoader struct {
@@ -18,12 +37,83 @@ type CommonIpLoader struct {
 }
 
 func AddressValidator(addr string) bool {
-	// IPv4 地址 + 必须的端口号
-	pattern := `^((25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?)\.){3}(25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?)(:[0-9]{1,5})$`
+	// 可选的协议 + 可选的 user:pass@ + IPv4 地址 + 必须的端口号
+	pattern := `^((https?|socks5h?)://)?([^:@/\s]+:[^@/\s]*@)?((25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?)\.){3}(25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?)(:[0-9]{1,5})$`
 	re := regexp.MustCompile(pattern)
 	return re.MatchString(addr)
 }
 
+// 上游代理协议
+const (
+	ProtocolHTTP    = "http"
+	ProtocolHTTPS   = "https"
+	ProtocolSocks5  = "socks5"
+	ProtocolSocks5H = "socks5h"
+)
+
+// ValidProtocol 检查是否为支持的上游代理协议, 空值视为 http
+func ValidProtocol(protocol string) bool {
+	switch protocol {
+	case "", ProtocolHTTP, ProtocolHTTPS, ProtocolSocks5, ProtocolSocks5H:
+		return true
+	}
+	return false
+}
+
+// ParseAddress 解析 [scheme://][user:pass@]ip:port 形式的地址, 省略协议时为 http
+func ParseAddress(addr string) (*url.URL, error) {
+	if !strings.Contains(addr, "://") {
+		addr = ProtocolHTTP + "://" + addr
+	}
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	if !ValidProtocol(u.Scheme) {
+		return nil, fmt.Errorf("unknown protocol: %s", u.Scheme)
+	}
+	return u, nil
+}
+
+// redactedUserinfo 隐藏认证信息后的占位
+const redactedUserinfo = "xxxxx"
+
+// RedactAddress 隐藏地址中的认证信息, 用于日志与管理接口
+func RedactAddress(addr string) string {
+	at := strings.LastIndex(addr, "@")
+	if at < 0 {
+		return addr
+	}
+	start := 0
+	if i := strings.Index(addr, "://"); i >= 0 && i < at {
+		start = i + len("://")
+	}
+	return addr[:start] + redactedUserinfo + addr[at:]
+}
+
+// ProxyAuthorization 生成 Basic 认证的 Proxy-Authorization 头
+func ProxyAuthorization(user *url.Userinfo) string {
+	password, _ := user.Password()
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(user.Username()+":"+password))
+}
+
+// normalizeAddress 地址未携带认证信息或协议时补充代理源级别的配置, http 协议省略前缀
+func normalizeAddress(addr string, username string, password string, protocol string) string {
+	hasScheme := strings.Contains(addr, "://")
+	if username != "" && !strings.Contains(addr, "@") {
+		if hasScheme {
+			scheme, rest, _ := strings.Cut(addr, "://")
+			addr = scheme + "://" + url.UserPassword(username, password).String() + "@" + rest
+		} else {
+			addr = url.UserPassword(username, password).String() + "@" + addr
+		}
+	}
+	if !hasScheme && protocol != "" && protocol != ProtocolHTTP {
+		addr = protocol + "://" + addr
+	}
+	return addr
+}
+
 // SplitIPs 根据 \r\n 或 \n 或 \r 切分 IP 字符串，并检查有效性
 func (r *CommonIpLoader) splitIp(ipStr string) ([]string, error) {
 	ipStr = strings.ReplaceAll(ipStr, "\r\n", "\n")
@@ -45,7 +135,7 @@ func (r *CommonIpLoader) splitIp(ipStr string) ([]string, error) {
 	return validIPs, nil
 }
 
-func (r *CommonIpLoader) GetAddress() ([]string, error) {
+func (r *CommonIpLoader) GetAddress() ([]Address, error) {
 	resp, err := http.Get(r.FetchURL)
 	if err != nil {
 		return nil, err
@@ -63,13 +153,13 @@ func (r *CommonIpLoader) GetAddress() ([]string, error) {
 	if err != nil {
 		return nil, fmt.Errorf("fetch ip error: %s", string(body))
 	}
-	return ips, nil
+	return toAddresses(ips), nil
 }
 
 type FixedIpLoader struct {
 	IPs []string
 }
 
-func (f *FixedIpLoader) GetAddress() ([]string, error) {
-	return f.IPs, nil
+func (f *FixedIpLoader) GetAddress() ([]Address, error) {
+	return toAddresses(f.IPs), nil
 }
@@ -0,0 +1,169 @@
+package pool
+
+import (
+	"bytes"
+	"easy-http-proxy-pool/pkg/conf"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultExpireLayout 过期时间字符串的默认格式
+const defaultExpireLayout = time.DateTime
+
+// JSONLoader 从返回 json 的接口中按字段路径提取地址
+// 单个元素无效时跳过该元素, 不影响整批地址
+type JSONLoader struct {
+	FetchURL string
+	Paths    conf.JSONPath
+}
+
+// lookupJSON 按 . 分隔的路径取值, 数组下标使用数字
+func lookupJSON(v any, path string) (any, bool) {
+	if path == "" {
+		return v, true
+	}
+	for _, key := range strings.Split(path, ".") {
+		switch node := v.(type) {
+		case map[string]any:
+			child, ok := node[key]
+			if !ok {
+				return nil, false
+			}
+			v = child
+		case []any:
+			idx, err := strconv.Atoi(key)
+			if err != nil || idx < 0 || idx >= len(node) {
+				return nil, false
+			}
+			v = node[idx]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// jsonString 将字符串或数字字段统一转换为字符串
+func jsonString(v any) string {
+	switch val := v.(type) {
+	case string:
+		return strings.TrimSpace(val)
+	case json.Number:
+		return val.String()
+	case bool:
+		return strconv.FormatBool(val)
+	}
+	return ""
+}
+
+// lookupString 按路径取字符串值, 路径为空或不存在时返回空字符串
+func lookupString(v any, path string) string {
+	if path == "" {
+		return ""
+	}
+	val, ok := lookupJSON(v, path)
+	if !ok {
+		return ""
+	}
+	return jsonString(val)
+}
+
+// parseExpire 解析过期时间, 数字视为 unix 时间戳(秒或毫秒), 字符串按 layout 以本地时区解析
+func parseExpire(value string, layout string) (time.Time, error) {
+	if ts, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if ts > 1e12 {
+			return time.UnixMilli(ts), nil
+		}
+		return time.Unix(ts, 0), nil
+	}
+	if layout == "" {
+		layout = defaultExpireLayout
+	}
+	t, err := time.ParseInLocation(layout, value, time.Local)
+	if err == nil {
+		return t, nil
+	}
+	if t, rfcErr := time.Parse(time.RFC3339, value); rfcErr == nil {
+		return t, nil
+	}
+	return time.Time{}, err
+}
+
+// parseItem 解析列表中的单个元素
+func (j *JSONLoader) parseItem(item any) (Address, error) {
+	addr := lookupString(item, j.Paths.Addr)
+	if addr == "" {
+		ip := lookupString(item, j.Paths.IP)
+		port := lookupString(item, j.Paths.Port)
+		if ip == "" || port == "" {
+			return Address{}, fmt.Errorf("缺少 ip 或端口字段")
+		}
+		addr = ip + ":" + port
+	}
+	if !AddressValidator(addr) {
+		return Address{}, fmt.Errorf("无效的 IP 地址: %s", addr)
+	}
+	if username := lookupString(item, j.Paths.Username); username != "" && !strings.Contains(addr, "@") {
+		password := lookupString(item, j.Paths.Password)
+		addr = url.UserPassword(username, password).String() + "@" + addr
+	}
+	result := Address{Addr: addr}
+	if expire := lookupString(item, j.Paths.Expire); expire != "" {
+		expireAt, err := parseExpire(expire, j.Paths.ExpireLayout)
+		if err != nil {
+			return Address{}, fmt.Errorf("无效的过期时间 %s: %w", expire, err)
+		}
+		result.ExpireAt = expireAt
+	}
+	return result, nil
+}
+
+func (j *JSONLoader) GetAddress() ([]Address, error) {
+	resp, err := http.Get(j.FetchURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if (resp.StatusCode / 100) != 2 {
+		return nil, fmt.Errorf("fetch ip error: %s", resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var doc any
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("fetch ip error: %s", string(body))
+	}
+	list, ok := lookupJSON(doc, j.Paths.List)
+	if !ok {
+		return nil, fmt.Errorf("fetch ip error: %s", string(body))
+	}
+	items, ok := list.([]any)
+	if !ok {
+		// 只返回一个地址时部分接口不包装为数组
+		items = []any{list}
+	}
+	addrs := make([]Address, 0, len(items))
+	for _, item := range items {
+		addr, err := j.parseItem(item)
+		if err != nil {
+			slog.Debug(fmt.Sprintf("跳过无效的代理地址: %s", err))
+			continue
+		}
+		addrs = append(addrs, addr)
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("fetch ip error: %s", string(body))
+	}
+	return addrs, nil
+}
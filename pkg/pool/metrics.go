@@ -0,0 +1,14 @@
+package pool
+
+import "easy-http-proxy-pool/pkg/metrics"
+
+var (
+	sourceFetchTotal = metrics.NewCounterVec("proxy_source_fetch_total",
+		"Address fetches from proxy sources by result (success/failure).", "source", "result")
+	sourceDisabledTotal = metrics.NewCounterVec("proxy_source_disabled_total",
+		"Times a proxy source has been disabled.", "source")
+	addressFailuresTotal = metrics.NewCounterVec("proxy_address_failures_total",
+		"Upstream address failures reported by the proxy, by source and reason.", "source", "reason")
+	addressBreakerOpenTotal = metrics.NewCounterVec("proxy_address_breaker_open_total",
+		"Times an upstream address circuit breaker has opened.", "source")
+)
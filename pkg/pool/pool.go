@@ -4,22 +4,80 @@ import (
 	"easy-http-proxy-pool/pkg/conf"
 	"fmt"
 	"log/slog"
+	"slices"
 	"sync"
 	"time"
 )
 
 type Pool interface {
-	GetAddress() (string, error)
+	// GetAddress 获取地址, 跳过被目标主机 host 封禁的地址, host 为空时不检查封禁
+	GetAddress(host string) (string, error)
+	// ReleaseAddress 归还通过 GetAddress 获取的地址, 用于统计活跃连接数
+	ReleaseAddress(addr string)
 	DisableAddress(addr string)
+	// ReportFailure 报告地址使用失败, 按失败原因计入熔断或移除地址
+	ReportFailure(addr string, reason string)
+	// ReportSuccess 报告地址使用成功, 半开状态的地址恢复正常
+	ReportSuccess(addr string)
+	// BanAddress 地址被目标主机封禁, 封禁期间访问该主机时不再选择该地址, 访问其他主机不受影响
+	BanAddress(addr string, host string)
+}
+
+// 地址失败原因
+// FailureDial 无法连接上游代理
+// FailureHandshake 与上游代理握手时连接异常或响应无法解析
+// FailureAuth 上游代理认证失败(407), 地址直接移除
+// FailureForbidden 上游代理拒绝访问目标(403), 与目标有关, 不计入熔断
+// FailureRejected 上游代理以其他状态拒绝建立隧道, 通常是目标不可达, 不计入熔断
+// FailureRequest 经上游代理的 http 请求失败
+// FailureTimeout 连接、握手或等待响应超时
+// FailureStatus 经上游代理的 http 请求返回 502/503/504
+// FailureBanned 目标站点封禁了该地址, 与目标有关, 不计入熔断, 通过 BanAddress 按主机封禁
+const (
+	FailureDial      = "dial"
+	FailureHandshake = "handshake"
+	FailureAuth      = "auth"
+	FailureForbidden = "forbidden"
+	FailureRejected  = "rejected"
+	FailureRequest   = "request"
+	FailureTimeout   = "timeout"
+	FailureStatus    = "status"
+	FailureBanned    = "banned"
+)
+
+// keepOnFailure 失败原因与目标有关, 地址本身可用
+func keepOnFailure(reason string) bool {
+	return reason == FailureForbidden || reason == FailureRejected || reason == FailureBanned
+}
+
+// evictOnFailure 失败原因说明地址不可能恢复
+func evictOnFailure(reason string) bool {
+	return reason == FailureAuth
 }
 
 // DisableableSource 代理源存储
 // DisableableSource.Disable 初始禁用时间15秒，不断翻倍, 最长120分钟
+// DisableableSource.blockedFetchAt 最近一次因缓存地址全部被封禁、熔断或隔离而提取的时间
 type DisableableSource struct {
 	conf.ProxySource
 	disabledAt     time.Time
 	disabledFor    time.Duration
 	disabledReason string
+	blockedFetchAt time.Time
+}
+
+// minBlockedFetchInterval 因缓存地址不可用而提取的最短间隔
+const minBlockedFetchInterval = time.Second
+
+// allowBlockedFetch 缓存中仍有未过期地址但都被封禁、熔断或隔离时, 每 ttl 的四分之一最多提取一次
+// 避免访问同一主机的每个请求都同步请求代理源, 消耗提取额度
+func (s *DisableableSource) allowBlockedFetch(now time.Time) bool {
+	interval := max(s.TTL/4, minBlockedFetchInterval)
+	if now.Before(s.blockedFetchAt.Add(interval)) {
+		return false
+	}
+	s.blockedFetchAt = now
+	return true
 }
 
 func (s *DisableableSource) IsDisabled() bool {
@@ -48,16 +106,65 @@ func (s *DisableableSource) Disable(reason string) {
 	}
 }
 
+// ExpiringAddr 缓存的代理地址
+// ExpiringAddr.lastUsed 最近一次被选中的时间
+// ExpiringAddr.active 活跃连接数
+// ExpiringAddr.weight 所属代理源的权重
+// ExpiringAddr.refreshAt 到达该时间后视为即将过期, 后台预取会补充新地址
+// ExpiringAddr.latency 最近一次健康检查的延迟
+// ExpiringAddr.quarantinedUntil 健康检查失败后隔离到该时间
+// ExpiringAddr.source 所属代理源, 未命名的代理源也能区分
+// ExpiringAddr.breaker 按请求结果熔断
+// ExpiringAddr.pinned ttl 过短而直接使用的地址, 归还前不会作为过期地址移除, 移除时照常触发 OnRemove
 type ExpiringAddr struct {
-	addr       string
-	expiration time.Time
+	addr             string
+	source           *DisableableSource
+	expiration       time.Time
+	refreshAt        time.Time
+	lastUsed         time.Time
+	active           int
+	weight           int
+	latency          time.Duration
+	quarantinedUntil time.Time
+	breaker          breaker
+	pinned           bool
+}
+
+// usable 地址未过期、未被隔离且未熔断
+func (e *ExpiringAddr) usable(now time.Time) bool {
+	return e.expiration.After(now) && !e.quarantinedUntil.After(now) && e.breaker.allow(now)
+}
+
+// take 占用地址
+func (e *ExpiringAddr) take(now time.Time) {
+	e.lastUsed = now
+	e.active++
+	e.breaker.take(now)
 }
 
 // DynamicPool 动态代理池
+// DynamicPool.mu 保护地址缓存与代理源状态, 加载地址期间不持有
+// DynamicPool.fetchMu 保证同一时间只有一个加载过程
+// DynamicPool.sessions 会话与地址的绑定关系
+// DynamicPool.onRemove 地址过期或被移除时的回调
+// DynamicPool.breaker 地址熔断配置
+// DynamicPool.bans 地址被目标主机封禁的记录
 type DynamicPool struct {
-	sources   []*DisableableSource
-	mu        sync.Mutex
-	addrStore []*ExpiringAddr
+	sources       []*DisableableSource
+	selector      Selector
+	strategy      string
+	mu            sync.Mutex
+	fetchMu       sync.Mutex
+	addrStore     []*ExpiringAddr
+	minAddress    int
+	prefetchRatio float64
+	healthCheck   *conf.HealthCheck
+	sessions      *sessionTable
+	breaker       breakerConfig
+	bans          *banList
+	onRemove      func(addr string)
+	done          chan struct{}
+	closeOnce     sync.Once
 }
 
 func NewDynamicPool(config *conf.Config) *DynamicPool {
@@ -65,38 +172,174 @@ func NewDynamicPool(config *conf.Config) *DynamicPool {
 	for i, item := range config.ProxySources {
 		s[i] = &DisableableSource{ProxySource: *item}
 	}
-	return &DynamicPool{addrStore: make([]*ExpiringAddr, 0), sources: s}
+	r := &DynamicPool{
+		addrStore: make([]*ExpiringAddr, 0),
+		sources:   s,
+		sessions:  newSessionTable(),
+		bans:      newBanList(),
+		done:      make(chan struct{}),
+	}
+	r.applyConfig(config)
+	return r
 }
 
-func (r *DynamicPool) cacheAddr(addr string, ttl time.Duration) {
+// applyConfig 应用代理源以外的配置, 策略未变化时保留选择器状态
+func (r *DynamicPool) applyConfig(config *conf.Config) {
+	if r.selector == nil || config.Strategy != r.strategy {
+		selector, err := NewSelector(config.Strategy)
+		if err != nil {
+			slog.Warn(fmt.Sprintf("地址选择策略无效, 使用默认策略: %s", err))
+			selector = &firstSelector{}
+		}
+		r.selector = selector
+		r.strategy = config.Strategy
+	}
+	ratio := config.PrefetchRatio
+	if ratio <= 0 || ratio > 1 {
+		ratio = defaultPrefetchRatio
+	}
+	r.minAddress = config.MinAddress
+	r.prefetchRatio = ratio
+	r.healthCheck = config.HealthCheck
+	r.sessions.configure(config.Session)
+	r.breaker = newBreakerConfig(config.Breaker)
+	r.bans.configure(config.BanTTL)
+}
+
+// cacheAddr 缓存地址, expireAt 为零值时按代理源的 ttl 计算过期时间
+func (r *DynamicPool) cacheAddr(addr string, expireAt time.Time, source *DisableableSource) {
+	weight := source.Weight
+	if weight <= 0 {
+		weight = 1
+	}
+	now := time.Now()
+	ttl := source.TTL
+	if !expireAt.IsZero() {
+		ttl = expireAt.Sub(now)
+	}
+	expiration := now.Add(ttl)
+	refreshAt := now.Add(time.Duration(float64(ttl) * r.prefetchRatio))
+	for _, item := range r.addrStore {
+		// 重复提取到的地址只刷新过期时间
+		if item.addr == addr {
+			item.expiration = expiration
+			item.refreshAt = refreshAt
+			item.weight = weight
+			return
+		}
+	}
 	r.addrStore = append(r.addrStore, &ExpiringAddr{
 		addr:       addr,
-		expiration: time.Now().Add(ttl),
+		source:     source,
+		expiration: expiration,
+		refreshAt:  refreshAt,
+		weight:     weight,
 	})
 }
 
-func (r *DynamicPool) peekAddr() (string, bool) {
-	if len(r.addrStore) == 0 {
-		var zero string
-		return zero, false
+// OnRemove 设置地址过期或被移除时的回调, 回调时持有代理池的锁, 不能阻塞或调用代理池的方法
+func (r *DynamicPool) OnRemove(fn func(addr string)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.onRemove = fn
+}
+
+// removed 通知地址已移除, 调用方需持有 mu
+func (r *DynamicPool) removed(addr string) {
+	if r.onRemove != nil {
+		r.onRemove(addr)
 	}
-	for i, item := range r.addrStore {
-		if item.expiration.After(time.Now()) {
-			// 前面的过期了了
-			r.addrStore = r.addrStore[i:]
-			return item.addr, true
+}
+
+// removeExpired 移除过期的地址
+func (r *DynamicPool) removeExpired() {
+	now := time.Now()
+	alive := r.addrStore[:0]
+	for _, item := range r.addrStore {
+		if item.expiration.After(now) || item.pinned && item.active > 0 {
+			alive = append(alive, item)
+		} else {
+			r.removed(item.addr)
 		}
 	}
-	// 全部过期了
-	r.addrStore = make([]*ExpiringAddr, 0)
-	var zero string
-	return zero, false
+	// 清理尾部引用, 避免过期地址无法回收
+	clear(r.addrStore[len(alive):])
+	r.addrStore = alive
+}
+
+// sourceSet 代理源名称集合, nil 表示全部代理源
+type sourceSet map[string]bool
+
+func newSourceSet(names []string) sourceSet {
+	if len(names) == 0 {
+		return nil
+	}
+	set := make(sourceSet, len(names))
+	for _, name := range names {
+		set[name] = true
+	}
+	return set
+}
+
+func (s sourceSet) contains(name string) bool {
+	return s == nil || s[name]
+}
+
+// candidates 可供选择的地址, 跳过不可用、被 host 封禁与排除的地址, 有更快的地址时跳过慢速地址
+func (r *DynamicPool) candidates(sources sourceSet, host string, exclude map[string]bool) []*ExpiringAddr {
+	now := time.Now()
+	var maxLatency time.Duration
+	if r.healthCheck != nil {
+		maxLatency = r.healthCheck.MaxLatency
+	}
+	var fast, slow []*ExpiringAddr
+	for _, item := range r.addrStore {
+		if !item.usable(now) || !sources.contains(item.source.Name) || exclude[item.addr] || r.bans.banned(item.addr, host, now) {
+			continue
+		}
+		if maxLatency > 0 && item.latency > maxLatency {
+			slow = append(slow, item)
+		} else {
+			fast = append(fast, item)
+		}
+	}
+	if len(fast) > 0 {
+		return fast
+	}
+	return slow
+}
+
+// blocked 缓存中是否有属于指定代理源、未过期且未排除, 但被 host 封禁或不可用(隔离、熔断)的地址
+func (r *DynamicPool) blocked(sources sourceSet, host string, exclude map[string]bool) bool {
+	now := time.Now()
+	for _, item := range r.addrStore {
+		if !item.expiration.After(now) || !sources.contains(item.source.Name) || exclude[item.addr] {
+			continue
+		}
+		if !item.usable(now) || r.bans.banned(item.addr, host, now) {
+			return true
+		}
+	}
+	return false
+}
+
+// peekAddr 按选择策略挑选一个可用的地址
+func (r *DynamicPool) peekAddr(sources sourceSet, host string, exclude map[string]bool) (string, bool) {
+	r.removeExpired()
+	addrs := r.candidates(sources, host, exclude)
+	if len(addrs) == 0 {
+		var zero string
+		return zero, false
+	}
+	item := r.selector.Select(addrs)
+	item.take(time.Now())
+	return item.addr, true
 }
 
 // peekSource 寻找一个可用的代理源
-func (r *DynamicPool) peekSource() (*DisableableSource, bool) {
+func (r *DynamicPool) peekSource(sources sourceSet) (*DisableableSource, bool) {
 	for _, item := range r.sources {
-		if !item.IsDisabled() {
+		if !item.IsDisabled() && sources.contains(item.Name) {
 			return item, true
 		}
 	}
@@ -104,57 +347,287 @@ func (r *DynamicPool) peekSource() (*DisableableSource, bool) {
 }
 
 // fetchAddress 从指定源中加载一个地址
-func (r *DynamicPool) fetchAddress(source *conf.ProxySource) ([]string, error) {
+func (r *DynamicPool) fetchAddress(source *conf.ProxySource) ([]Address, error) {
 	var loader Loader
 	switch source.Type {
 	case "fixed":
 		loader = &FixedIpLoader{IPs: source.FixedAddr}
-	case "common":
-	case "":
+	case "common", "":
 		loader = &CommonIpLoader{FetchURL: source.FetchURL}
+	case "json":
+		if source.JSON == nil {
+			return nil, fmt.Errorf("json source requires json config: %s", source.Name)
+		}
+		loader = &JSONLoader{FetchURL: source.FetchURL, Paths: *source.JSON}
 	}
 	if loader == nil {
 		return nil, fmt.Errorf("unknown source type: %s", source.Type)
 	}
+	if !ValidProtocol(source.Protocol) {
+		return nil, fmt.Errorf("unknown protocol: %s", source.Protocol)
+	}
 	return loader.GetAddress()
 }
 
-func (r *DynamicPool) GetAddress() (string, error) {
+// refill 从可用代理源加载一批地址到缓存, 返回加载到的地址与所属代理源
+// need 在获取加载锁后再次检查, 避免并发调用重复请求代理源
+func (r *DynamicPool) refill(sources sourceSet, need func() bool) ([]string, *DisableableSource, error) {
+	r.fetchMu.Lock()
+	defer r.fetchMu.Unlock()
 	r.mu.Lock()
-	defer r.mu.Unlock()
-	if peek, ok := r.peekAddr(); ok {
-		return peek, nil
+	if !need() {
+		r.mu.Unlock()
+		return nil, nil, nil
 	}
-	s, ok := r.peekSource()
+	s, ok := r.peekSource(sources)
+	r.mu.Unlock()
 	if !ok {
-		return "", fmt.Errorf("无可用代理源")
+		return nil, nil, fmt.Errorf("无可用代理源")
+	}
+	ips, err := r.fetchFrom(s)
+	return ips, s, err
+}
+
+// fetchFrom 从指定代理源加载地址并缓存, 调用方需持有 fetchMu
+func (r *DynamicPool) fetchFrom(s *DisableableSource) ([]string, error) {
+	// 加载期间不持有 mu, 慢速的代理源接口不会阻塞其他请求
+	addrs, err := r.fetchAddress(&s.ProxySource)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !slices.Contains(r.sources, s) {
+		// 加载期间配置已重新加载, 代理源被移除或修改
+		return nil, fmt.Errorf("代理源已变更: %s", s.Name)
 	}
-	ips, err := r.fetchAddress(&s.ProxySource)
 	if err != nil {
+		sourceFetchTotal.Inc(s.Name, "failure")
 		// 禁用
 		s.Disable(err.Error())
+		sourceDisabledTotal.Inc(s.Name)
 		slog.Warn("代理源已禁用",
 			slog.String("source", s.Name),
 			slog.String("reason", s.disabledReason),
 			slog.Duration("disabledFor", s.disabledFor),
 		)
+		return nil, err
+	}
+	sourceFetchTotal.Inc(s.Name, "success")
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("代理源未返回可用地址: %s", s.Name)
+	}
+	ips := make([]string, len(addrs))
+	for i, item := range addrs {
+		addr := normalizeAddress(item.Addr, s.Username, s.Password, s.Protocol)
+		ips[i] = addr
+		r.cacheAddr(addr, item.ExpireAt, s)
+		slog.Debug(fmt.Sprintf("提取代理地址 %s", RedactAddress(addr)), slog.String("source", s.Name))
+	}
+	return ips, nil
+}
+
+// acquire 地址可用、属于指定的代理源且未被 host 封禁时占用该地址
+func (r *DynamicPool) acquire(addr string, sources sourceSet, host string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	host = banHost(host)
+	for _, item := range r.addrStore {
+		if item.addr == addr {
+			if !item.usable(now) || !sources.contains(item.source.Name) || r.bans.banned(addr, host, now) {
+				return false
+			}
+			item.take(now)
+			return true
+		}
+	}
+	return false
+}
+
+func (r *DynamicPool) acquireAddr(addr string, host string) bool {
+	return r.acquire(addr, nil, host)
+}
+
+func (r *DynamicPool) sessionTable() *sessionTable {
+	return r.sessions
+}
+
+// lockedPeekAddr 加锁后挑选地址
+func (r *DynamicPool) lockedPeekAddr(sources sourceSet, host string, exclude map[string]bool) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.peekAddr(sources, host, exclude)
+}
+
+func (r *DynamicPool) GetAddress(host string) (string, error) {
+	return r.getAddress(nil, host, nil)
+}
+
+func (r *DynamicPool) getAddressExcluding(host string, exclude map[string]bool) (string, error) {
+	return r.getAddress(nil, host, exclude)
+}
+
+// getAddress 从指定的代理源中获取地址, 跳过被 host 封禁与 exclude 中的地址
+func (r *DynamicPool) getAddress(sources sourceSet, host string, exclude map[string]bool) (string, error) {
+	host = banHost(host)
+	if peek, ok := r.lockedPeekAddr(sources, host, exclude); ok {
+		return peek, nil
+	}
+	ips, source, err := r.refill(sources, func() bool {
+		r.removeExpired()
+		if len(r.candidates(sources, host, exclude)) > 0 {
+			return false
+		}
+		if !r.blocked(sources, host, exclude) {
+			return true
+		}
+		s, ok := r.peekSource(sources)
+		return !ok || s.allowBlockedFetch(time.Now())
+	})
+	if err != nil {
 		return "", err
 	}
+	if peek, ok := r.lockedPeekAddr(sources, host, exclude); ok {
+		return peek, nil
+	}
+	// ttl 过短, 缓存即过期, 直接使用本次提取的地址, 跳过仍在缓存中但不可用(隔离或熔断)的地址
+	if addr, ok := r.lockedTakeUncached(ips, source, host, exclude); ok {
+		return addr, nil
+	}
+	return "", fmt.Errorf("无可用代理地址")
+}
+
+// lockedTakeUncached 占用第一个不在缓存中或已过期且未被封禁、排除的地址
+// 地址以 pinned 保留在缓存中直到归还, 之后随过期地址移除并触发 OnRemove, 对应的 Transport 不会泄漏
+func (r *DynamicPool) lockedTakeUncached(ips []string, source *DisableableSource, host string, exclude map[string]bool) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
 	for _, addr := range ips {
-		r.cacheAddr(addr, s.TTL)
-		slog.Debug(fmt.Sprintf("提取代理地址 %s", addr), slog.String("source", s.Name))
+		if exclude[addr] || r.bans.banned(addr, host, now) {
+			continue
+		}
+		item, ok := r.findAddr(addr)
+		if ok && item.expiration.After(now) {
+			continue
+		}
+		if !ok {
+			// 并发请求已将过期地址移除
+			item = &ExpiringAddr{addr: addr, source: source, expiration: now, weight: 1}
+			r.addrStore = append(r.addrStore, item)
+		}
+		item.pinned = true
+		item.take(now)
+		return addr, true
+	}
+	return "", false
+}
+
+// ReleaseAddress 归还地址, 活跃连接数减一
+func (r *DynamicPool) ReleaseAddress(addr string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, expiringAddr := range r.addrStore {
+		if expiringAddr.addr == addr {
+			if expiringAddr.active > 0 {
+				expiringAddr.active--
+			}
+			expiringAddr.breaker.release(expiringAddr.active)
+			return
+		}
 	}
-	return ips[0], nil
 }
 
 // DisableAddress 禁用指定的地址
 func (r *DynamicPool) DisableAddress(addr string) {
+	r.EvictAddress(addr)
+}
+
+// findAddr 查找缓存的地址, 调用方需持有 mu
+func (r *DynamicPool) findAddr(addr string) (*ExpiringAddr, bool) {
+	for _, item := range r.addrStore {
+		if item.addr == addr {
+			return item, true
+		}
+	}
+	return nil, false
+}
+
+// ReportFailure 记录失败原因, 认证失败的地址直接移除, 其他与地址有关的失败计入熔断
+func (r *DynamicPool) ReportFailure(addr string, reason string) {
+	r.mu.Lock()
+	item, ok := r.findAddr(addr)
+	if !ok {
+		r.mu.Unlock()
+		addressFailuresTotal.Inc("", reason)
+		return
+	}
+	addressFailuresTotal.Inc(item.source.Name, reason)
+	if keepOnFailure(reason) {
+		r.mu.Unlock()
+		slog.Debug(fmt.Sprintf("代理地址失败, 保留地址 %s", RedactAddress(addr)), slog.String("reason", reason))
+		return
+	}
+	if !evictOnFailure(reason) {
+		opened := item.breaker.record(r.breaker, true, time.Now())
+		cooldown := item.breaker.cooldown
+		r.mu.Unlock()
+		if opened {
+			addressBreakerOpenTotal.Inc(item.source.Name)
+			slog.Info(fmt.Sprintf("代理地址失败率过高, 熔断 %s", RedactAddress(addr)),
+				slog.String("reason", reason),
+				slog.Duration("cooldown", cooldown))
+		}
+		return
+	}
+	r.mu.Unlock()
+	if r.EvictAddress(addr) {
+		slog.Debug(fmt.Sprintf("代理地址失败, 已移除 %s", RedactAddress(addr)), slog.String("reason", reason))
+	}
+}
+
+// ReportSuccess 记录成功, 半开状态的地址恢复正常
+func (r *DynamicPool) ReportSuccess(addr string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	item, ok := r.findAddr(addr)
+	if !ok {
+		return
+	}
+	now := time.Now()
+	recovered := item.breaker.state(now) == BreakerHalfOpen && item.breaker.probing
+	item.breaker.record(r.breaker, false, now)
+	if recovered {
+		slog.Info(fmt.Sprintf("代理地址试探成功, 恢复使用 %s", RedactAddress(addr)))
+	}
+}
+
+// BanAddress 按目标主机封禁地址, 地址仍保留在缓存中供其他主机使用
+func (r *DynamicPool) BanAddress(addr string, host string) {
+	host = banHost(host)
+	if host == "" {
+		return
+	}
+	r.mu.Lock()
+	until := r.bans.ban(addr, host, time.Now())
+	source := ""
+	if item, ok := r.findAddr(addr); ok {
+		source = item.source.Name
+	}
+	r.mu.Unlock()
+	addressFailuresTotal.Inc(source, FailureBanned)
+	slog.Debug(fmt.Sprintf("代理地址被 %s 封禁 %s", host, RedactAddress(addr)), slog.Time("until", until))
+}
+
+// EvictAddress 从缓存中移除指定的地址, 地址不存在时返回 false
+// addr 也可以是管理接口返回的隐藏了认证信息的地址
+func (r *DynamicPool) EvictAddress(addr string) bool {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	for i, expiringAddr := range r.addrStore {
-		if expiringAddr.addr == addr {
+		if expiringAddr.addr == addr || RedactAddress(expiringAddr.addr) == addr {
 			r.addrStore = append(r.addrStore[:i], r.addrStore[i+1:]...)
-			return
+			r.removed(expiringAddr.addr)
+			return true
 		}
 	}
+	return false
 }
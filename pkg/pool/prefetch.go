@@ -0,0 +1,65 @@
+package pool
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// defaultPrefetchRatio 地址存活超过 ttl 的 80% 后开始预取
+const defaultPrefetchRatio = 0.8
+
+// prefetchInterval 后台预取检查间隔
+const prefetchInterval = time.Second
+
+// Start 启动后台预取与健康检查, 未配置时后台任务空转, 以便重新加载配置后生效
+func (r *DynamicPool) Start() {
+	go r.prefetchLoop()
+	go r.healthLoop()
+}
+
+// Close 停止后台任务
+func (r *DynamicPool) Close() {
+	r.closeOnce.Do(func() {
+		close(r.done)
+	})
+}
+
+// warmCount 统计未进入预取窗口的地址数量
+func (r *DynamicPool) warmCount() int {
+	now := time.Now()
+	count := 0
+	for _, item := range r.addrStore {
+		if item.usable(now) && item.refreshAt.After(now) {
+			count++
+		}
+	}
+	return count
+}
+
+// prefetchLoop 保持缓存中至少有 minAddress 个未临近过期的地址
+func (r *DynamicPool) prefetchLoop() {
+	ticker := time.NewTicker(prefetchInterval)
+	defer ticker.Stop()
+	for {
+		r.prefetch()
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *DynamicPool) prefetch() {
+	_, _, err := r.refill(nil, func() bool {
+		if r.minAddress <= 0 {
+			return false
+		}
+		r.removeExpired()
+		return r.warmCount() < r.minAddress
+	})
+	if err != nil {
+		slog.Debug(fmt.Sprintf("后台预取代理地址失败: %s", err))
+	}
+}
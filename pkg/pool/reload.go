@@ -0,0 +1,62 @@
+package pool
+
+import (
+	"easy-http-proxy-pool/pkg/conf"
+	"log/slog"
+	"reflect"
+)
+
+// sourceKey 重新加载时匹配新旧代理源的键, 有名称时按名称, 未命名的代理源按位置
+// sourceKey.index 有名称时为 -1
+type sourceKey struct {
+	name  string
+	index int
+}
+
+func newSourceKey(index int, source *conf.ProxySource) sourceKey {
+	if source.Name != "" {
+		return sourceKey{name: source.Name, index: -1}
+	}
+	return sourceKey{index: index}
+}
+
+// Reload 重新加载配置
+// 配置未变化的代理源保留禁用状态与已缓存的地址, 新增或修改的代理源重新开始, 已移除代理源的地址被丢弃
+// 已分配出去的地址不受影响, 进行中的连接不会被中断
+func (r *DynamicPool) Reload(config *conf.Config) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	old := make(map[sourceKey]*DisableableSource, len(r.sources))
+	for i, item := range r.sources {
+		old[newSourceKey(i, &item.ProxySource)] = item
+	}
+	sources := make([]*DisableableSource, len(config.ProxySources))
+	kept := make(map[*DisableableSource]bool, len(config.ProxySources))
+	for i, item := range config.ProxySources {
+		if prev, ok := old[newSourceKey(i, item)]; ok && !kept[prev] && reflect.DeepEqual(prev.ProxySource, *item) {
+			sources[i] = prev
+			kept[prev] = true
+			continue
+		}
+		sources[i] = &DisableableSource{ProxySource: *item}
+	}
+	r.sources = sources
+
+	alive := r.addrStore[:0]
+	for _, item := range r.addrStore {
+		if kept[item.source] {
+			alive = append(alive, item)
+		} else {
+			r.removed(item.addr)
+		}
+	}
+	clear(r.addrStore[len(alive):])
+	r.addrStore = alive
+
+	r.applyConfig(config)
+	slog.Info("代理池配置已更新",
+		slog.Int("sources", len(sources)),
+		slog.Int("keptSources", len(kept)),
+		slog.Int("addresses", len(alive)),
+	)
+}
@@ -0,0 +1,187 @@
+package pool
+
+import (
+	"container/list"
+	"easy-http-proxy-pool/pkg/conf"
+	"sync"
+	"time"
+)
+
+// DefaultSessionHeader 客户端传递会话标识的默认请求头
+const DefaultSessionHeader = "X-Proxy-Session"
+
+// 会话表默认配置
+const (
+	defaultSessionTTL     = 10 * time.Minute
+	defaultSessionMaxSize = 10000
+)
+
+// stickyPool 可以绑定会话的代理池
+type stickyPool interface {
+	filteredPool
+	// acquireAddr 地址仍可用且未被 host 封禁时占用该地址
+	acquireAddr(addr string, host string) bool
+	sessionTable() *sessionTable
+}
+
+// sessionEntry 会话绑定的地址
+type sessionEntry struct {
+	key      string
+	addr     string
+	expireAt time.Time
+}
+
+// sessionTable 会话表, 会话空闲超过 ttl 后过期, 超过 maxSize 时淘汰最久未使用的会话
+// sessionTable.lru 队首为最近使用的会话
+type sessionTable struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	maxSize int
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+func newSessionTable() *sessionTable {
+	return &sessionTable{
+		ttl:     defaultSessionTTL,
+		maxSize: defaultSessionMaxSize,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// configure 应用配置, 未配置的项使用默认值
+func (t *sessionTable) configure(c *conf.Session) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.ttl = defaultSessionTTL
+	t.maxSize = defaultSessionMaxSize
+	if c != nil && c.TTL > 0 {
+		t.ttl = c.TTL
+	}
+	if c != nil && c.MaxSize > 0 {
+		t.maxSize = c.MaxSize
+	}
+	t.evict()
+}
+
+// evict 淘汰超出数量限制的会话, 调用方需持有 mu
+func (t *sessionTable) evict() {
+	for t.lru.Len() > t.maxSize {
+		t.remove(t.lru.Back())
+	}
+}
+
+// remove 移除会话, 调用方需持有 mu
+func (t *sessionTable) remove(elem *list.Element) {
+	t.lru.Remove(elem)
+	delete(t.entries, elem.Value.(*sessionEntry).key)
+}
+
+// get 返回会话绑定的地址, 会话过期时移除
+func (t *sessionTable) get(key string) (string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	elem, ok := t.entries[key]
+	if !ok {
+		return "", false
+	}
+	entry := elem.Value.(*sessionEntry)
+	if !entry.expireAt.After(time.Now()) {
+		t.remove(elem)
+		return "", false
+	}
+	return entry.addr, true
+}
+
+// bind 绑定会话与地址, 已存在时刷新过期时间
+func (t *sessionTable) bind(key string, addr string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	expireAt := time.Now().Add(t.ttl)
+	if elem, ok := t.entries[key]; ok {
+		entry := elem.Value.(*sessionEntry)
+		entry.addr = addr
+		entry.expireAt = expireAt
+		t.lru.MoveToFront(elem)
+		return
+	}
+	t.entries[key] = t.lru.PushFront(&sessionEntry{key: key, addr: addr, expireAt: expireAt})
+	t.evict()
+}
+
+// unbind 会话仍绑定 addr 时解除绑定, 避免误删已重新绑定的会话
+func (t *sessionTable) unbind(key string, addr string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if elem, ok := t.entries[key]; ok && elem.Value.(*sessionEntry).addr == addr {
+		t.remove(elem)
+	}
+}
+
+// size 会话数量, 包括已过期但未清理的会话
+func (t *sessionTable) size() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.lru.Len()
+}
+
+// sessionView 绑定会话的代理池视图
+type sessionView struct {
+	pool stickyPool
+	key  string
+}
+
+// WithSession 返回绑定会话的视图, key 为空或代理池不支持会话时返回 p 本身
+// 返回的视图只能用于单个请求
+func WithSession(p Pool, key string) Pool {
+	sp, ok := p.(stickyPool)
+	if key == "" || !ok {
+		return p
+	}
+	return &sessionView{pool: sp, key: key}
+}
+
+// GetAddress 优先使用会话绑定的地址, 地址过期、被移除或被 host 封禁后重新选择并绑定
+func (v *sessionView) GetAddress(host string) (string, error) {
+	return v.getAddressExcluding(host, nil)
+}
+
+func (v *sessionView) getAddressExcluding(host string, exclude map[string]bool) (string, error) {
+	table := v.pool.sessionTable()
+	if addr, ok := table.get(v.key); ok && !exclude[addr] && v.pool.acquireAddr(addr, host) {
+		table.bind(v.key, addr)
+		return addr, nil
+	}
+	addr, err := v.pool.getAddressExcluding(host, exclude)
+	if err != nil {
+		return "", err
+	}
+	table.bind(v.key, addr)
+	return addr, nil
+}
+
+func (v *sessionView) ReleaseAddress(addr string) {
+	v.pool.ReleaseAddress(addr)
+}
+
+func (v *sessionView) DisableAddress(addr string) {
+	v.pool.sessionTable().unbind(v.key, addr)
+	v.pool.DisableAddress(addr)
+}
+
+// ReportFailure 任何原因的失败都解除会话绑定, 下次请求重新选择地址
+func (v *sessionView) ReportFailure(addr string, reason string) {
+	v.pool.sessionTable().unbind(v.key, addr)
+	v.pool.ReportFailure(addr, reason)
+}
+
+func (v *sessionView) ReportSuccess(addr string) {
+	v.pool.ReportSuccess(addr)
+}
+
+// BanAddress 解除会话绑定, 会话之后的请求重新选择地址
+func (v *sessionView) BanAddress(addr string, host string) {
+	v.pool.sessionTable().unbind(v.key, addr)
+	v.pool.BanAddress(addr, host)
+}
@@ -0,0 +1,161 @@
+package pool
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// ErrSourceNotFound 按名称操作的代理源不存在
+var ErrSourceNotFound = errors.New("代理源不存在")
+
+// SourceState 代理源状态
+type SourceState struct {
+	Name           string    `json:"name"`
+	Type           string    `json:"type"`
+	Disabled       bool      `json:"disabled"`
+	DisabledAt     time.Time `json:"disabledAt"`
+	DisabledFor    string    `json:"disabledFor"`
+	DisabledReason string    `json:"disabledReason"`
+	Addresses      int       `json:"addresses"`
+}
+
+// AddressState 缓存地址状态
+// AddressState.Addr 隐藏了认证信息的地址
+type AddressState struct {
+	Addr             string    `json:"addr"`
+	Source           string    `json:"source"`
+	Expiration       time.Time `json:"expiration"`
+	LastUsed         time.Time `json:"lastUsed"`
+	Active           int       `json:"active"`
+	Latency          string    `json:"latency"`
+	Quarantined      bool      `json:"quarantined"`
+	QuarantinedUntil time.Time `json:"quarantinedUntil"`
+	Breaker          string    `json:"breaker"`
+	BreakerOpenUntil time.Time `json:"breakerOpenUntil"`
+	BannedHosts      []string  `json:"bannedHosts"`
+}
+
+// SessionCount 会话数量
+func (r *DynamicPool) SessionCount() int {
+	return r.sessions.size()
+}
+
+// findSource 按名称查找代理源
+func (r *DynamicPool) findSource(name string) (*DisableableSource, bool) {
+	for _, item := range r.sources {
+		if item.Name == name {
+			return item, true
+		}
+	}
+	return nil, false
+}
+
+// Sources 列出代理源状态
+func (r *DynamicPool) Sources() []SourceState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.removeExpired()
+	counts := make(map[*DisableableSource]int)
+	for _, item := range r.addrStore {
+		counts[item.source]++
+	}
+	result := make([]SourceState, len(r.sources))
+	for i, item := range r.sources {
+		result[i] = SourceState{
+			Name:           item.Name,
+			Type:           item.Type,
+			Disabled:       item.IsDisabled(),
+			DisabledAt:     item.disabledAt,
+			DisabledFor:    item.disabledFor.String(),
+			DisabledReason: item.disabledReason,
+			Addresses:      counts[item],
+		}
+	}
+	return result
+}
+
+// Size 未过期的缓存地址数量
+func (r *DynamicPool) Size() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.removeExpired()
+	return len(r.addrStore)
+}
+
+// Addresses 列出未过期的缓存地址
+func (r *DynamicPool) Addresses() []AddressState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.removeExpired()
+	now := time.Now()
+	result := make([]AddressState, len(r.addrStore))
+	for i, item := range r.addrStore {
+		result[i] = AddressState{
+			Addr:             RedactAddress(item.addr),
+			Source:           item.source.Name,
+			Expiration:       item.expiration,
+			LastUsed:         item.lastUsed,
+			Active:           item.active,
+			Latency:          item.latency.String(),
+			Quarantined:      item.quarantinedUntil.After(now),
+			QuarantinedUntil: item.quarantinedUntil,
+			Breaker:          item.breaker.state(now),
+			BreakerOpenUntil: item.breaker.openUntil,
+			BannedHosts:      r.bans.hosts(item.addr, now),
+		}
+	}
+	return result
+}
+
+// EnableSource 强制启用代理源
+func (r *DynamicPool) EnableSource(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s, ok := r.findSource(name)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrSourceNotFound, name)
+	}
+	s.Enable()
+	slog.Info("代理源已手动启用", slog.String("source", name))
+	return nil
+}
+
+// DisableSource 强制禁用代理源, duration 大于 0 时使用指定时长, 否则按退避规则计算
+func (r *DynamicPool) DisableSource(name string, reason string, duration time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s, ok := r.findSource(name)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrSourceNotFound, name)
+	}
+	s.Disable(reason)
+	sourceDisabledTotal.Inc(s.Name)
+	if duration > 0 {
+		s.disabledFor = duration
+	}
+	slog.Warn("代理源已手动禁用",
+		slog.String("source", s.Name),
+		slog.String("reason", s.disabledReason),
+		slog.Duration("disabledFor", s.disabledFor),
+	)
+	return nil
+}
+
+// Refetch 立即从代理源加载地址, name 为空时选择第一个可用的代理源
+func (r *DynamicPool) Refetch(name string) ([]string, error) {
+	if name == "" {
+		ips, _, err := r.refill(nil, func() bool { return true })
+		return ips, err
+	}
+	r.fetchMu.Lock()
+	defer r.fetchMu.Unlock()
+	r.mu.Lock()
+	s, ok := r.findSource(name)
+	r.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrSourceNotFound, name)
+	}
+	return r.fetchFrom(s)
+}
@@ -0,0 +1,111 @@
+package pool
+
+import (
+	"fmt"
+	"math/rand/v2"
+)
+
+// 地址选择策略名称
+const (
+	StrategyFirst      = "first"
+	StrategyRoundRobin = "round-robin"
+	StrategyRandom     = "random"
+	StrategyLRU        = "lru"
+	StrategyLeastConn  = "least-conn"
+	StrategyWeighted   = "weighted"
+)
+
+// Selector 地址选择策略
+// Selector.Select 从可用地址中选出一个, addrs 保证非空
+type Selector interface {
+	Select(addrs []*ExpiringAddr) *ExpiringAddr
+}
+
+// NewSelector 根据名称创建选择策略, 名称为空时沿用旧行为(始终取第一个)
+func NewSelector(name string) (Selector, error) {
+	switch name {
+	case "", StrategyFirst:
+		return &firstSelector{}, nil
+	case StrategyRoundRobin:
+		return &roundRobinSelector{}, nil
+	case StrategyRandom:
+		return &randomSelector{}, nil
+	case StrategyLRU:
+		return &lruSelector{}, nil
+	case StrategyLeastConn:
+		return &leastConnSelector{}, nil
+	case StrategyWeighted:
+		return &weightedSelector{}, nil
+	}
+	return nil, fmt.Errorf("unknown strategy: %s", name)
+}
+
+// firstSelector 始终选择最早缓存的地址
+type firstSelector struct{}
+
+func (s *firstSelector) Select(addrs []*ExpiringAddr) *ExpiringAddr {
+	return addrs[0]
+}
+
+// roundRobinSelector 轮询
+type roundRobinSelector struct {
+	next int
+}
+
+func (s *roundRobinSelector) Select(addrs []*ExpiringAddr) *ExpiringAddr {
+	// 地址列表会增减, 取模保证下标有效
+	idx := s.next % len(addrs)
+	s.next = idx + 1
+	return addrs[idx]
+}
+
+// randomSelector 随机
+type randomSelector struct{}
+
+func (s *randomSelector) Select(addrs []*ExpiringAddr) *ExpiringAddr {
+	return addrs[rand.IntN(len(addrs))]
+}
+
+// lruSelector 最久未使用
+type lruSelector struct{}
+
+func (s *lruSelector) Select(addrs []*ExpiringAddr) *ExpiringAddr {
+	selected := addrs[0]
+	for _, item := range addrs[1:] {
+		if item.lastUsed.Before(selected.lastUsed) {
+			selected = item
+		}
+	}
+	return selected
+}
+
+// leastConnSelector 活跃连接数最少
+type leastConnSelector struct{}
+
+func (s *leastConnSelector) Select(addrs []*ExpiringAddr) *ExpiringAddr {
+	selected := addrs[0]
+	for _, item := range addrs[1:] {
+		if item.active < selected.active {
+			selected = item
+		}
+	}
+	return selected
+}
+
+// weightedSelector 按代理源权重加权随机
+type weightedSelector struct{}
+
+func (s *weightedSelector) Select(addrs []*ExpiringAddr) *ExpiringAddr {
+	total := 0
+	for _, item := range addrs {
+		total += item.weight
+	}
+	n := rand.IntN(total)
+	for _, item := range addrs {
+		if n < item.weight {
+			return item
+		}
+		n -= item.weight
+	}
+	return addrs[len(addrs)-1]
+}
@@ -0,0 +1,101 @@
+package pool
+
+// sourceView 只使用指定代理源的代理池视图, 与所属代理池共享地址缓存
+type sourceView struct {
+	pool    *DynamicPool
+	sources sourceSet
+}
+
+// WithSources 返回只使用指定代理源的视图, names 为空时返回代理池本身
+func (r *DynamicPool) WithSources(names []string) Pool {
+	if len(names) == 0 {
+		return r
+	}
+	return &sourceView{pool: r, sources: newSourceSet(names)}
+}
+
+func (v *sourceView) GetAddress(host string) (string, error) {
+	return v.pool.getAddress(v.sources, host, nil)
+}
+
+func (v *sourceView) getAddressExcluding(host string, exclude map[string]bool) (string, error) {
+	return v.pool.getAddress(v.sources, host, exclude)
+}
+
+func (v *sourceView) ReleaseAddress(addr string) {
+	v.pool.ReleaseAddress(addr)
+}
+
+func (v *sourceView) DisableAddress(addr string) {
+	v.pool.DisableAddress(addr)
+}
+
+func (v *sourceView) ReportFailure(addr string, reason string) {
+	v.pool.ReportFailure(addr, reason)
+}
+
+func (v *sourceView) ReportSuccess(addr string) {
+	v.pool.ReportSuccess(addr)
+}
+
+func (v *sourceView) BanAddress(addr string, host string) {
+	v.pool.BanAddress(addr, host)
+}
+
+func (v *sourceView) acquireAddr(addr string, host string) bool {
+	return v.pool.acquire(addr, v.sources, host)
+}
+
+func (v *sourceView) sessionTable() *sessionTable {
+	return v.pool.sessions
+}
+
+// filteredPool 获取地址时可以排除指定地址的代理池
+type filteredPool interface {
+	Pool
+	getAddressExcluding(host string, exclude map[string]bool) (string, error)
+}
+
+// attemptView 单个请求使用的代理池视图
+// attemptView.failed 本次请求中报告失败或被封禁的地址, 重试时不再选择
+type attemptView struct {
+	pool   filteredPool
+	failed map[string]bool
+}
+
+// WithAttempts 返回单个请求使用的视图, 重试时不会再次选择本次请求中失败的地址
+// 地址失败后仍可能保留在代理池中(熔断未触发), 视图保证重试会更换地址
+func WithAttempts(p Pool) Pool {
+	fp, ok := p.(filteredPool)
+	if !ok {
+		return p
+	}
+	return &attemptView{pool: fp, failed: make(map[string]bool)}
+}
+
+func (v *attemptView) GetAddress(host string) (string, error) {
+	return v.pool.getAddressExcluding(host, v.failed)
+}
+
+func (v *attemptView) ReleaseAddress(addr string) {
+	v.pool.ReleaseAddress(addr)
+}
+
+func (v *attemptView) DisableAddress(addr string) {
+	v.failed[addr] = true
+	v.pool.DisableAddress(addr)
+}
+
+func (v *attemptView) ReportFailure(addr string, reason string) {
+	v.failed[addr] = true
+	v.pool.ReportFailure(addr, reason)
+}
+
+func (v *attemptView) ReportSuccess(addr string) {
+	v.pool.ReportSuccess(addr)
+}
+
+func (v *attemptView) BanAddress(addr string, host string) {
+	v.failed[addr] = true
+	v.pool.BanAddress(addr, host)
+}
@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+)
+
+// maxReplayBody 可重放的请求体大小上限, 超过后请求体流式转发, 不再重试
+const maxReplayBody = 1 << 20
+
+// maxLogBody 调试日志中打印的报文长度上限
+const maxLogBody = 4 << 10
+
+// readCloser 读取 Reader, 关闭时关闭原始 Body
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// bufferBody 预读请求体, 不超过 maxReplayBody 时缓存以便重放, 否则将已读部分拼接回去继续流式转发
+func bufferBody(r *http.Request) (replayable bool, err error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return true, nil
+	}
+	if r.GetBody != nil {
+		return true, nil
+	}
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxReplayBody+1))
+	if err != nil {
+		return false, err
+	}
+	if len(data) > maxReplayBody {
+		r.Body = &readCloser{Reader: io.MultiReader(bytes.NewReader(data), r.Body), Closer: r.Body}
+		return false, nil
+	}
+	r.Body.Close()
+	r.ContentLength = int64(len(data))
+	r.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+	r.Body, _ = r.GetBody()
+	return true, nil
+}
+
+// peekBody 读取不超过 n 字节的前缀, 返回的 Body 仍可读到完整内容
+func peekBody(body io.ReadCloser, n int64) ([]byte, io.ReadCloser) {
+	if body == nil || body == http.NoBody {
+		return nil, body
+	}
+	prefix, _ := io.ReadAll(io.LimitReader(body, n))
+	return prefix, &readCloser{Reader: io.MultiReader(bytes.NewReader(prefix), body), Closer: body}
+}
+
+// streaming 响应长度未知(chunked、读到连接关闭)或为 SSE 时需要边读边发送给客户端
+func streaming(res *http.Response) bool {
+	mediaType, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	return res.ContentLength < 0 || mediaType == "text/event-stream"
+}
+
+// flushWriter 每次写入后立即发送给客户端, 不在 ResponseWriter 的缓冲区中积攒
+type flushWriter struct {
+	w  io.Writer
+	rc *http.ResponseController
+}
+
+func (f *flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	if n > 0 {
+		f.rc.Flush()
+	}
+	return n, err
+}
@@ -0,0 +1,45 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestBufferBody(t *testing.T) {
+	t.Run("小请求体可重放", func(t *testing.T) {
+		r, _ := http.NewRequest(http.MethodPost, "http://example.com", io.NopCloser(strings.NewReader("hello")))
+		ok, err := bufferBody(r)
+		if err != nil || !ok {
+			t.Fatal(ok, err)
+		}
+		for i := 0; i < 2; i++ {
+			cpr, _ := copyRequest(r)
+			body, _ := io.ReadAll(cpr.Body)
+			if string(body) != "hello" || cpr.ContentLength != 5 {
+				t.Fatal(string(body), cpr.ContentLength)
+			}
+		}
+	})
+	t.Run("大请求体流式转发", func(t *testing.T) {
+		data := bytes.Repeat([]byte("x"), maxReplayBody+10)
+		r, _ := http.NewRequest(http.MethodPost, "http://example.com", io.NopCloser(bytes.NewReader(data)))
+		ok, err := bufferBody(r)
+		if err != nil || ok || r.GetBody != nil {
+			t.Fatal(ok, err)
+		}
+		body, _ := io.ReadAll(r.Body)
+		if !bytes.Equal(body, data) {
+			t.Fatal(len(body))
+		}
+	})
+	t.Run("日志只读取前缀", func(t *testing.T) {
+		prefix, body := peekBody(io.NopCloser(strings.NewReader("0123456789")), 4)
+		rest, _ := io.ReadAll(body)
+		if string(prefix) != "0123" || string(rest) != "0123456789" {
+			t.Fatal(string(prefix), string(rest))
+		}
+	})
+}
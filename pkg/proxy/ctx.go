@@ -4,6 +4,7 @@ import (
 	"easy-http-proxy-pool/pkg/conf"
 	"easy-http-proxy-pool/pkg/middleware"
 	"easy-http-proxy-pool/pkg/pool"
+	"easy-http-proxy-pool/pkg/rule"
 	"log/slog"
 	"net/http"
 )
@@ -12,14 +13,56 @@ type ProxyCtx struct {
 	// Will contain the client request from the proxy
 	Req  *http.Request
 	Pool pool.Pool
-	conf *conf.Config
+	// User 认证通过的客户端用户名, 未开启认证时为空
+	User string
+	// Rule 请求命中的路由规则
+	Rule *rule.Rule
+	// Session 客户端传递的会话标识, 同一会话使用同一代理地址
+	Session string
+	conf    *conf.Config
+	// headers 转发头部的处理方式
+	headers *headerPolicy
+	// transports 按上游地址共用的 Transport
+	transports *transportCache
+	// proxyAddr 当前占用的代理地址, 请求结束后归还
+	proxyAddr string
+	// route 请求实际的路由方式, 用于统计
+	route string
+	// timeouts 命中规则的超时
+	timeouts timeouts
+}
+
+// acquireProxyAddr 从代理池获取未被目标主机封禁的地址并记录, 以便结束时归还
+func (ctx *ProxyCtx) acquireProxyAddr() (string, error) {
+	addr, err := ctx.Pool.GetAddress(ctx.Req.Host)
+	if err != nil {
+		return "", err
+	}
+	ctx.proxyAddr = addr
+	return addr, nil
+}
+
+// releaseProxyAddr 归还当前占用的代理地址
+func (ctx *ProxyCtx) releaseProxyAddr() {
+	if ctx.proxyAddr == "" {
+		return
+	}
+	ctx.Pool.ReleaseAddress(ctx.proxyAddr)
+	ctx.proxyAddr = ""
 }
 
 func (ctx *ProxyCtx) getReqInfo() []interface{} {
-	return []interface{}{
+	info := []interface{}{
 		"host", ctx.Req.Host,
 		"tranceId", middleware.GetReqID(ctx.Req.Context()),
 	}
+	if ctx.User != "" {
+		info = append(info, "user", ctx.User)
+	}
+	if ctx.Session != "" {
+		info = append(info, "session", ctx.Session)
+	}
+	return info
 }
 
 func (ctx *ProxyCtx) Log(level slog.Level, msg string, argv ...interface{}) {
@@ -0,0 +1,124 @@
+package proxy
+
+import (
+	"easy-http-proxy-pool/pkg/conf"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// hopHeaders 逐跳头部, 只对相邻的一跳有效, 不能转发
+// https://www.rfc-editor.org/rfc/rfc7230#section-6.1
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// removeHopHeaders 移除逐跳头部, 包括 Connection 中列出的头部
+func removeHopHeaders(h http.Header) {
+	for _, v := range h.Values("Connection") {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				h.Del(name)
+			}
+		}
+	}
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+}
+
+// 转发头部的处理方式
+// forwardStrip 移除, 不向目标站点暴露客户端与代理
+// forwardAdd 追加本代理的信息
+// forwardPass 原样转发
+const (
+	forwardStrip = "strip"
+	forwardAdd   = "add"
+	forwardPass  = "pass"
+)
+
+// viaPseudonym Via 头部中本代理的名称
+const viaPseudonym = "easy-http-proxy-pool"
+
+// clientIPHeaders 可能暴露客户端 IP 的头部, strip 时一并移除
+var clientIPHeaders = []string{"X-Forwarded-For", "X-Real-Ip", "Forwarded"}
+
+// headerPolicy 转发头部的处理方式, 配置加载时构建
+type headerPolicy struct {
+	xForwardedFor string
+	via           string
+}
+
+// checkForwardMode 校验处理方式, 为空时默认 strip
+func checkForwardMode(name string, mode string) (string, error) {
+	switch mode {
+	case "":
+		return forwardStrip, nil
+	case forwardStrip, forwardAdd, forwardPass:
+		return mode, nil
+	}
+	return "", fmt.Errorf("%s 处理方式无效: %s", name, mode)
+}
+
+func newHeaderPolicy(c *conf.Forwarded) (*headerPolicy, error) {
+	if c == nil {
+		c = &conf.Forwarded{}
+	}
+	xff, err := checkForwardMode("xForwardedFor", c.XForwardedFor)
+	if err != nil {
+		return nil, err
+	}
+	via, err := checkForwardMode("via", c.Via)
+	if err != nil {
+		return nil, err
+	}
+	return &headerPolicy{xForwardedFor: xff, via: via}, nil
+}
+
+// viaValue 本代理的 Via 值, 如 1.1 easy-http-proxy-pool
+func viaValue(protoMajor int, protoMinor int) string {
+	return fmt.Sprintf("%d.%d %s", protoMajor, protoMinor, viaPseudonym)
+}
+
+// outgoing 处理发往上游的请求头, r 为客户端请求
+func (p *headerPolicy) outgoing(h http.Header, r *http.Request) {
+	removeHopHeaders(h)
+	switch p.xForwardedFor {
+	case forwardStrip:
+		for _, name := range clientIPHeaders {
+			h.Del(name)
+		}
+	case forwardAdd:
+		if ip, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			if prior := h.Values("X-Forwarded-For"); len(prior) > 0 {
+				ip = strings.Join(prior, ", ") + ", " + ip
+			}
+			h.Set("X-Forwarded-For", ip)
+		}
+	}
+	p.applyVia(h, r.ProtoMajor, r.ProtoMinor)
+}
+
+// incoming 处理返回给客户端的响应头
+func (p *headerPolicy) incoming(h http.Header, res *http.Response) {
+	removeHopHeaders(h)
+	p.applyVia(h, res.ProtoMajor, res.ProtoMinor)
+}
+
+func (p *headerPolicy) applyVia(h http.Header, protoMajor int, protoMinor int) {
+	switch p.via {
+	case forwardStrip:
+		h.Del("Via")
+	case forwardAdd:
+		h.Add("Via", viaValue(protoMajor, protoMinor))
+	}
+}
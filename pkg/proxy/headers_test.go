@@ -0,0 +1,59 @@
+package proxy
+
+import (
+	"easy-http-proxy-pool/pkg/conf"
+	"net/http"
+	"testing"
+)
+
+func TestHeaderPolicy(t *testing.T) {
+	newRequest := func() *http.Request {
+		r, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+		r.RemoteAddr = "10.0.0.1:5000"
+		r.Header.Set("Connection", "keep-alive, X-Custom")
+		r.Header.Set("X-Custom", "1")
+		r.Header.Set("Proxy-Connection", "keep-alive")
+		r.Header.Set("Proxy-Authorization", "Basic xxx")
+		r.Header.Set("X-Forwarded-For", "192.168.0.1")
+		r.Header.Set("Via", "1.1 other")
+		r.Header.Set("Accept", "*/*")
+		return r
+	}
+	t.Run("默认移除逐跳头部与客户端信息", func(t *testing.T) {
+		p, _ := newHeaderPolicy(nil)
+		r := newRequest()
+		p.outgoing(r.Header, r)
+		for _, name := range []string{"Connection", "X-Custom", "Proxy-Connection", "Proxy-Authorization", "X-Forwarded-For", "Via"} {
+			if r.Header.Get(name) != "" {
+				t.Fatal(name, "should be removed")
+			}
+		}
+		if r.Header.Get("Accept") != "*/*" {
+			t.Fatal("end-to-end header removed")
+		}
+	})
+	t.Run("追加转发信息", func(t *testing.T) {
+		p, _ := newHeaderPolicy(&conf.Forwarded{XForwardedFor: "add", Via: "add"})
+		r := newRequest()
+		p.outgoing(r.Header, r)
+		if v := r.Header.Get("X-Forwarded-For"); v != "192.168.0.1, 10.0.0.1" {
+			t.Fatal(v)
+		}
+		if v := r.Header.Values("Via"); len(v) != 2 || v[1] != "1.1 easy-http-proxy-pool" {
+			t.Fatal(v)
+		}
+	})
+	t.Run("原样转发", func(t *testing.T) {
+		p, _ := newHeaderPolicy(&conf.Forwarded{XForwardedFor: "pass", Via: "pass"})
+		r := newRequest()
+		p.outgoing(r.Header, r)
+		if r.Header.Get("X-Forwarded-For") != "192.168.0.1" || r.Header.Get("Via") != "1.1 other" {
+			t.Fatal(r.Header)
+		}
+	})
+	t.Run("无效的处理方式", func(t *testing.T) {
+		if _, err := newHeaderPolicy(&conf.Forwarded{Via: "keep"}); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+}
@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"easy-http-proxy-pool/pkg/middleware"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// singleConnListener 只返回一个连接的 Listener, 用于 http.Server 处理解密后的连接
+// 连接关闭后 Accept 返回错误, http.Server.Serve 随之退出
+type singleConnListener struct {
+	conn      net.Conn
+	accepted  bool
+	mu        sync.Mutex
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newSingleConnListener(conn net.Conn) *singleConnListener {
+	return &singleConnListener{conn: conn, done: make(chan struct{})}
+}
+
+func (l *singleConnListener) Accept() (net.Conn, error) {
+	l.mu.Lock()
+	if !l.accepted {
+		l.accepted = true
+		l.mu.Unlock()
+		return l.conn, nil
+	}
+	l.mu.Unlock()
+	<-l.done
+	return nil, net.ErrClosed
+}
+
+func (l *singleConnListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.done)
+	})
+	return nil
+}
+
+func (l *singleConnListener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
+
+// handleIntercept 解密 CONNECT 隧道, 使用 CA 签发的证书与客户端完成 TLS 握手
+// 解密后的请求按普通 http 请求重新匹配规则, 路径规则、重试与日志同样生效
+func (s *ProxyServer) handleIntercept(ctx *ProxyCtx, state *serverState, w http.ResponseWriter) {
+	hij, ok := w.(http.Hijacker)
+	if !ok {
+		panic("httpserver does not support hijacking")
+	}
+	clientConn, _, err := hij.Hijack()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		ctx.Error(err.Error())
+		return
+	}
+	requestsTotal.Inc(modeConnect, routeIntercept)
+	clientConn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+	host := ctx.Req.Host
+	hostname, _, err := net.SplitHostPort(host)
+	if err != nil {
+		hostname = host
+	}
+	tlsConn := tls.Server(clientConn, state.mitm.TLSConfig(hostname))
+	tlsConn.SetDeadline(time.Now().Add(ctx.timeouts.handshake))
+	if err := tlsConn.HandshakeContext(ctx.Req.Context()); err != nil {
+		ctx.Debug(fmt.Sprintf("解密握手失败, 客户端可能未信任 CA: %s", err))
+		clientConn.Close()
+		return
+	}
+	tlsConn.SetDeadline(time.Time{})
+	ctx.Debug(fmt.Sprintf("开始解密 %s", host))
+	l := newSingleConnListener(tlsConn)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Host == "" {
+			r.Host = host
+		}
+		r.URL.Scheme = "https"
+		r.URL.Host = r.Host
+		s.route(w, r, state, ctx.User, ctx.Session)
+	})
+	server := &http.Server{
+		Handler:     middleware.Recovery(middleware.RequestID(handler)),
+		IdleTimeout: ctx.timeouts.idle,
+		ConnState: func(conn net.Conn, cs http.ConnState) {
+			if cs == http.StateClosed || cs == http.StateHijacked {
+				l.Close()
+			}
+		},
+	}
+	server.Serve(l)
+}
@@ -0,0 +1,167 @@
+package proxy
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"easy-http-proxy-pool/pkg/conf"
+	"encoding/base64"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// writeTestCA 生成测试用 CA 并写入临时目录
+func writeTestCA(t *testing.T) (*conf.MITM, *x509.CertPool) {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, _ := x509.ParseCertificate(der)
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+	dir := t.TempDir()
+	c := &conf.MITM{CACert: filepath.Join(dir, "ca.crt"), CAKey: filepath.Join(dir, "ca.key")}
+	os.WriteFile(c.CACert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(c.CAKey, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	return c, roots
+}
+
+// newTunnelUpstream 模拟支持 CONNECT 的上游代理, 连接请求的目标并双向转发
+func newTunnelUpstream(t *testing.T) (string, *atomic.Int32) {
+	t.Helper()
+	var hits atomic.Int32
+	addr := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		target, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer target.Close()
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+		go io.Copy(target, conn)
+		io.Copy(conn, target)
+	})
+	return addr, &hits
+}
+
+// hijackRecorder 可以劫持的 ResponseWriter, 劫持后返回 conn
+type hijackRecorder struct {
+	*httptest.ResponseRecorder
+	conn net.Conn
+}
+
+func (h *hijackRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return h.conn, bufio.NewReadWriter(bufio.NewReader(h.conn), bufio.NewWriter(h.conn)), nil
+}
+
+func TestIntercept(t *testing.T) {
+	target := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello "+r.URL.Path)
+	}))
+	defer target.Close()
+	targetAddr := target.Listener.Addr().String()
+	mitmConf, roots := writeTestCA(t)
+	upstream, hits := newTunnelUpstream(t)
+	s := newTestServer(t, &conf.Config{
+		ProxySources: fixedSources(upstream),
+		MITM:         mitmConf,
+		Auth:         &conf.Auth{Users: map[string]string{"alice": "secret"}},
+		Rules: []*conf.Rule{
+			{Host: "127.0.0.1", Method: http.MethodConnect, MITM: true},
+			{Host: "127.0.0.1", Path: "/blocked", Action: "reject", Status: http.StatusUnavailableForLegalReasons},
+			{Host: "127.0.0.1", Fallback: "fail"},
+		},
+	})
+
+	client, server := net.Pipe()
+	defer client.Close()
+	req := httptest.NewRequest(http.MethodConnect, targetAddr, nil)
+	req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("alice-session-abc:secret")))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.ServeHTTP(&hijackRecorder{ResponseRecorder: httptest.NewRecorder(), conn: server}, req)
+	}()
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	br := bufio.NewReader(client)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatal(resp, err)
+	}
+
+	// 客户端信任测试 CA, 使用签发的证书完成握手
+	tlsConn := tls.Client(&bufferedConn{Conn: client, r: br}, &tls.Config{RootCAs: roots, ServerName: "127.0.0.1"})
+	if err := tlsConn.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	tr := bufio.NewReader(tlsConn)
+	get := func(path string) (*http.Response, string) {
+		r, _ := http.NewRequest(http.MethodGet, "https://"+targetAddr+path, nil)
+		if err := r.Write(tlsConn); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.ReadResponse(tr, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return resp, string(body)
+	}
+	t.Run("解密后的请求经上游代理访问目标, 沿用 CONNECT 的会话", func(t *testing.T) {
+		resp, body := get("/a")
+		if resp.StatusCode != http.StatusOK || body != "hello /a" {
+			t.Fatal(resp.Status, body)
+		}
+		if hits.Load() != 1 {
+			t.Fatal(hits.Load())
+		}
+		if n := s.Pools().Default().SessionCount(); n != 1 {
+			t.Fatal("decrypted request should use the connect session", n)
+		}
+	})
+	t.Run("解密后的请求重新匹配路径规则", func(t *testing.T) {
+		if resp, _ := get("/blocked"); resp.StatusCode != http.StatusUnavailableForLegalReasons {
+			t.Fatal(resp.Status)
+		}
+	})
+	t.Run("客户端关闭连接后解密服务退出", func(t *testing.T) {
+		tlsConn.Close()
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			t.Fatal("intercept server did not shut down")
+		}
+	})
+}
@@ -0,0 +1,42 @@
+package proxy
+
+import "easy-http-proxy-pool/pkg/metrics"
+
+// 请求模式
+const (
+	modeConnect = "connect"
+	modeHttp    = "http"
+	modeSocks5  = "socks5"
+)
+
+// 请求路由方式
+// routeFallback 需要代理但远程代理不可用, 降级为本地请求
+// routeBlock routeReject 被规则断开或拒绝
+// routeFailed 远程代理不可用且规则不允许降级
+// routeIntercept CONNECT 请求被解密, 解密后的请求另行统计
+const (
+	routeProxy     = "proxy"
+	routeDirect    = "direct"
+	routeFallback  = "fallback"
+	routeBlock     = "block"
+	routeReject    = "reject"
+	routeFailed    = "failed"
+	routeIntercept = "intercept"
+)
+
+// 隧道传输方向
+const (
+	directionUpload   = "upload"
+	directionDownload = "download"
+)
+
+var (
+	requestsTotal = metrics.NewCounterVec("proxy_requests_total",
+		"Client requests by mode (connect/http/socks5) and route (proxy/direct/fallback/failed/block/reject/intercept).", "mode", "route")
+	upstreamDialSeconds = metrics.NewHistogramVec("proxy_upstream_dial_seconds",
+		"Time to establish a connection or tunnel through an upstream proxy.", nil, "result")
+	tunnelBytesTotal = metrics.NewCounterVec("proxy_tunnel_bytes_total",
+		"Bytes transferred through CONNECT tunnels.", "direction")
+	bannedTotal = metrics.NewCounterVec("proxy_banned_responses_total",
+		"Responses detected as ban pages by condition (status/header/body/location).", "condition")
+)
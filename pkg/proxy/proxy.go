@@ -3,86 +3,331 @@ package proxy
 import (
 	"bytes"
 	"compress/gzip"
-	"crypto/tls"
+	"context"
 	"easy-http-proxy-pool/pkg/conf"
+	"easy-http-proxy-pool/pkg/pool"
+	"easy-http-proxy-pool/pkg/rule"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
+	"sync/atomic"
 	"time"
 )
 
+// errProxyUnavailable 远程代理不可用且规则不允许降级为直连
+var errProxyUnavailable = errors.New("远程代理不可用")
+
+// 普通 http 请求重试的默认配置
+const (
+	defaultRetryAttempts = 2
+	defaultRetryDeadline = 30 * time.Second
+)
+
+// copyRequest 复制请求, 请求体已缓存(GetBody 不为空)时副本使用新的读取位置, 否则与原请求共用同一个流
 func copyRequest(r *http.Request) (*http.Request, error) {
-	bodyBytes, err := io.ReadAll(r.Body)
-	r.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
-	if err != nil {
-		return nil, err
+	body := r.Body
+	if r.GetBody != nil {
+		b, err := r.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		body = b
 	}
-	//if conf.IsDebug {
-	//	headerText, _ := json.Marshal(r.Header)
-	//	ctx.Debug("发起代理请求",
-	//		slog.String("method", r.Method),
-	//		slog.String("url", r.URL.String()),
-	//		slog.String("headers", string(headerText)),
-	//		slog.String("body", string(bodyBytes)))
-	//}
-	request, err := http.NewRequest(r.Method, "", r.Body)
+	request, err := http.NewRequest(r.Method, "", body)
 	if err != nil {
 		return nil, err
 	}
 	request.URL = r.URL
-	request.Header = r.Header
+	request.Header = r.Header.Clone()
+	request.ContentLength = r.ContentLength
+	request.GetBody = r.GetBody
 	return request, err
 }
 
-func doRequest(r *http.Request, tr *http.Transport) (*http.Response, error) {
-	client := &http.Client{Timeout: 10 * time.Second, Transport: tr}
-	return client.Do(r)
+// doRequest 发起请求, timeout 为等待响应头的超时
+// keepRedirect 为 true 时不跟随跳转, 直接返回 3xx 响应
+// Transport 被多个请求共用, 超时通过取消请求实现, 响应体关闭后释放
+func doRequest(r *http.Request, tr *http.Transport, timeout time.Duration, keepRedirect bool) (*http.Response, error) {
+	reqCtx, cancel := context.WithCancel(r.Context())
+	timer := time.AfterFunc(timeout, cancel)
+	client := &http.Client{Transport: tr}
+	if keepRedirect {
+		client.CheckRedirect = func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+	}
+	resp, err := client.Do(r.WithContext(reqCtx))
+	timedOut := !timer.Stop()
+	if err == nil && timedOut {
+		resp.Body.Close()
+		err = context.DeadlineExceeded
+	}
+	if err != nil {
+		cancel()
+		if timedOut {
+			return nil, fmt.Errorf("等待响应超时(%s): %w", timeout, context.DeadlineExceeded)
+		}
+		return nil, err
+	}
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelBody 关闭响应体时释放请求的 context
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// detectBan 按规则的检测条件检查响应是否为封禁页面, 需要时预读响应体, resp.Body 仍可读到完整内容
+func detectBan(ctx *ProxyCtx, resp *http.Response) bool {
+	detector := ctx.Rule.Detector()
+	if detector == nil {
+		return false
+	}
+	var body []byte
+	if n, ok := detector.NeedsBody(); ok {
+		body, resp.Body = peekBody(resp.Body, int64(n))
+		if resp.Header.Get("Content-Encoding") == "gzip" {
+			body = tryUnzip(bytes.NewReader(body))
+		}
+	}
+	condition, banned := detector.Match(resp, body)
+	if !banned {
+		return false
+	}
+	bannedTotal.Inc(condition)
+	ctx.Info(fmt.Sprintf("检测到封禁页面, 代理地址 %s 被 %s 封禁", pool.RedactAddress(ctx.proxyAddr), ctx.Req.Host),
+		"condition", condition, "statusCode", resp.StatusCode)
+	return true
+}
+
+// isUpstreamFailureStatus 上游代理无法完成请求时常见的状态码, 计入地址熔断
+func isUpstreamFailureStatus(code int) bool {
+	return code == http.StatusBadGateway || code == http.StatusServiceUnavailable || code == http.StatusGatewayTimeout
+}
+
+// requestFailureReason 经上游代理的 http 请求失败的原因
+// https 目标的 CONNECT 被拒绝与上游代理的 407 按状态码区分, 其他错误计为请求失败
+func requestFailureReason(err error) string {
+	switch {
+	case isTimeout(err):
+		return pool.FailureTimeout
+	case errors.Is(err, errUpstreamAuth), errors.Is(err, errUpstreamForbidden), errors.Is(err, errUpstreamRejected):
+		return failureReason(err)
+	}
+	return pool.FailureRequest
+}
+
+// isIdempotent 幂等方法, 重复发送不会产生副作用
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// retryPolicy 普通 http 请求最多尝试的代理地址数量与总耗时上限
+// 规则配置了 retry-other-proxy 时按规则重试, 否则只有幂等方法按全局配置重试
+// 非幂等请求只在请求发出前失败(连接、握手、CONNECT 或上游认证失败)时重试, 见 proxyHttpRequest
+func retryPolicy(ctx *ProxyCtx, req *http.Request) (int, time.Duration) {
+	attempts, deadline := defaultRetryAttempts, defaultRetryDeadline
+	if c := ctx.conf.Retry; c != nil {
+		if c.MaxAttempts > 0 {
+			attempts = c.MaxAttempts
+		}
+		if c.Deadline > 0 {
+			deadline = c.Deadline
+		}
+	}
+	if ctx.Rule.Fallback == rule.FallbackRetry {
+		return ctx.Rule.Attempts(), deadline
+	}
+	if !isIdempotent(req.Method) {
+		return 1, deadline
+	}
+	return attempts, deadline
 }
 
 func getProxyUrl(ctx *ProxyCtx) (*url.URL, error) {
-	addr, err := ctx.Pool.GetAddress()
-	ctx.Debug(fmt.Sprintf("获取代理地址: %s", addr))
+	addr, err := ctx.acquireProxyAddr()
+	ctx.Debug(fmt.Sprintf("获取代理地址: %s", pool.RedactAddress(addr)))
 	if err != nil {
 		ctx.Warn(fmt.Sprintf("获取代理地址失败: %s", err))
 		return nil, err
 	}
-	proxyUrl, err := url.Parse("http://" + addr)
+	proxyUrl, err := pool.ParseAddress(addr)
 	if err != nil {
 		return nil, err
 	}
 	return proxyUrl, nil
 }
 
+// tryUnzip 尝试解压 gzip, 数据被截断时返回已解压的部分
 func tryUnzip(r io.Reader) []byte {
 	gzipReader, err := gzip.NewReader(r)
 	if err != nil {
 		return nil
 	}
-	unzipped, err := io.ReadAll(gzipReader)
-	if err != nil {
-		return nil
-	}
+	unzipped, _ := io.ReadAll(gzipReader)
 	return unzipped
 }
 
-// safetyHttpProxyRequest 安全的代理请求, 如果失败将会执行本机重试
-func safetyHttpProxyRequest(ctx *ProxyCtx, req *http.Request, proxyURL *url.URL) (*http.Response, error) {
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+// proxyHttpRequest 通过远程代理请求, 失败的地址报告给代理池, 按重试策略更换其他地址重试
+// 封禁页面重试后没有其他可用地址时返回最后一次的封禁页面, 不会降级直连
+func proxyHttpRequest(ctx *ProxyCtx, req *http.Request, attempts int, total time.Duration) (*http.Response, error) {
+	deadline := time.Now().Add(total)
+	tried := make(map[string]bool, attempts)
+	var lastErr error
+	// banned 最后一次检测到的封禁页面, 收到新的响应后才关闭
+	var banned *http.Response
+	for attempt := 1; attempt <= attempts; attempt++ {
+		remaining := time.Until(deadline)
+		if (lastErr != nil || banned != nil) && remaining <= 0 {
+			ctx.Debug("重试超过总耗时上限, 停止重试")
+			break
+		}
+		// 归还上一次失败的地址
+		ctx.releaseProxyAddr()
+		proxyUrl, err := getProxyUrl(ctx)
+		if err != nil {
+			if lastErr != nil || banned != nil {
+				break
+			}
+			return nil, err
+		}
+		if tried[ctx.proxyAddr] {
+			ctx.Debug(fmt.Sprintf("没有其他可用的代理地址, 停止重试: %s", pool.RedactAddress(ctx.proxyAddr)))
+			break
+		}
+		tried[ctx.proxyAddr] = true
+		cpr, err := copyRequest(req)
+		if err != nil {
+			closeResponse(banned)
+			return nil, err
+		}
+		// sent 请求已写出到上游代理, connected 已取得到上游代理的连接
+		var sent, connected atomic.Bool
+		start := time.Now()
+		cpr = cpr.WithContext(httptrace.WithClientTrace(cpr.Context(), &httptrace.ClientTrace{
+			GotConn: func(info httptrace.GotConnInfo) {
+				connected.Store(true)
+				// 复用的连接没有建立耗时, 只统计新建的连接
+				if !info.Reused {
+					upstreamDialSeconds.Observe(time.Since(start).Seconds(), "success")
+				}
+			},
+			WroteHeaders: func() { sent.Store(true) },
+		}))
+		tr := ctx.transports.get(ctx.proxyAddr, proxyUrl, ctx.timeouts)
+		// 检测跳转地址时不能跟随跳转, 否则看不到跳转到验证页面的响应
+		keepRedirect := ctx.Rule.Detector() != nil && ctx.Rule.Detector().ChecksLocation()
+		resp, err := doRequest(cpr, tr, min(ctx.timeouts.request, remaining), keepRedirect)
+		if err == nil {
+			// 收到新的响应, 之前的封禁页面不再需要
+			closeResponse(banned)
+			banned = nil
+		}
+		if err == nil && detectBan(ctx, resp) {
+			ctx.Pool.BanAddress(ctx.proxyAddr, ctx.Req.Host)
+			if !ctx.Rule.Detector().Retry || attempt == attempts {
+				return resp, nil
+			}
+			banned = resp
+			ctx.Debug(fmt.Sprintf("第 %d 次代理请求被封禁 %s, 更换代理地址重试", attempt, pool.RedactAddress(ctx.proxyAddr)))
+			continue
+		}
+		if err == nil && resp.StatusCode == http.StatusProxyAuthRequired {
+			// 上游代理认证失败, 不把上游的 407 返回给客户端, 更换地址重试
+			resp.Body.Close()
+			err = fmt.Errorf("%w: %s", errUpstreamAuth, resp.Status)
+		}
+		if err == nil {
+			if isUpstreamFailureStatus(resp.StatusCode) {
+				ctx.Pool.ReportFailure(ctx.proxyAddr, pool.FailureStatus)
+			} else {
+				ctx.Pool.ReportSuccess(ctx.proxyAddr)
+			}
+			return resp, nil
+		}
+		lastErr = err
+		if !connected.Load() {
+			upstreamDialSeconds.Observe(time.Since(start).Seconds(), "failure")
+		}
+		// 报告失败的地址计入熔断, 绑定该地址的会话也会解除绑定
+		reason := requestFailureReason(err)
+		ctx.Pool.ReportFailure(ctx.proxyAddr, reason)
+		ctx.Debug(fmt.Sprintf("第 %d 次代理请求失败 %s: %s", attempt, pool.RedactAddress(ctx.proxyAddr), err), "reason", reason)
+		// 上游认证失败时请求没有转发给目标, 其他情况下请求已发出的非幂等请求重试可能重复提交
+		if !isIdempotent(req.Method) && sent.Load() && !errors.Is(err, errUpstreamAuth) {
+			ctx.Debug("非幂等请求已发出, 不再重试")
+			break
+		}
+	}
+	if banned != nil {
+		ctx.Debug("没有其他可用的代理地址, 返回封禁页面")
+		return banned, nil
+	}
+	return nil, lastErr
+}
+
+// closeResponse 关闭响应体, resp 为空时忽略
+func closeResponse(resp *http.Response) {
+	if resp != nil {
+		resp.Body.Close()
+	}
+}
+
+// safetyHttpProxyRequest 安全的代理请求, 远程代理不可用时按规则的降级策略处理
+// 只有可能重试或降级时才缓存请求体, 请求体过大无法重放时不再重试与降级
+// 规则不允许降级或无法降级时返回 errProxyUnavailable
+func safetyHttpProxyRequest(ctx *ProxyCtx, req *http.Request) (*http.Response, error) {
+	if !checkHostnameNeedProxy(ctx) {
+		ctx.route = routeDirect
+		return doRequest(req, ctx.transports.get(directKey, nil, ctx.timeouts), ctx.timeouts.request, false)
+	}
+	attempts, total := retryPolicy(ctx, req)
+	replayable := false
+	if attempts > 1 || ctx.Rule.Fallback == rule.FallbackDirect {
+		ok, err := bufferBody(req)
+		if err != nil {
+			return nil, err
+		}
+		replayable = ok
+	}
+	if !replayable && attempts > 1 {
+		ctx.Debug(fmt.Sprintf("请求体超过 %d 字节, 不再重试", maxReplayBody))
+		attempts = 1
 	}
-	if proxyURL != nil {
-		tr.Proxy = http.ProxyURL(proxyURL)
+	resp, err := proxyHttpRequest(ctx, req, attempts, total)
+	if err == nil {
+		ctx.route = routeProxy
+		return resp, nil
 	}
-	resp, err := doRequest(req, tr)
-	if err != nil && proxyURL != nil {
-		ctx.Debug(fmt.Sprintf("代理请求失败: %s", err))
-		cpr, _ := copyRequest(req)
-		return safetyHttpProxyRequest(ctx, cpr, nil)
+	// 已获取到代理地址说明请求已发出, 请求体无法重放时不能降级
+	if ctx.Rule.Fallback != rule.FallbackDirect || (!replayable && ctx.proxyAddr != "") {
+		ctx.route = routeFailed
+		return nil, fmt.Errorf("%w: %s", errProxyUnavailable, err)
 	}
-	return resp, err
+	ctx.Debug(fmt.Sprintf("当前远程代理不可用，降级为本地请求: %s", err))
+	ctx.route = routeFallback
+	cpr, err := copyRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	return doRequest(cpr, ctx.transports.get(directKey, nil, ctx.timeouts), ctx.timeouts.request, false)
 }
 
 // safetyLogRequest 安全的打印请求报文
@@ -90,11 +335,8 @@ func safetyLogRequest(ctx *ProxyCtx, req *http.Request) {
 	if !conf.IsDebug {
 		return
 	}
-	bodyBytes, err := io.ReadAll(req.Body)
-	if err != nil {
-		return
-	}
-	req.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
+	var bodyBytes []byte
+	bodyBytes, req.Body = peekBody(req.Body, maxLogBody)
 	headerText, err := json.Marshal(ctx.Req.Header)
 	if err != nil {
 		return
@@ -106,19 +348,24 @@ func safetyLogRequest(ctx *ProxyCtx, req *http.Request) {
 		slog.String("body", string(bodyBytes)))
 }
 
-// safetyLogResponse 安全的打印响应报文
+// safetyLogResponse 安全的打印响应报文, 流式响应不预读响应体, 避免等待数据时阻塞转发
 func safetyLogResponse(ctx *ProxyCtx, res *http.Response) {
 	if !conf.IsDebug {
 		return
 	}
-	bodyBytes, err := io.ReadAll(res.Body)
-	res.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
+	var bodyBytes []byte
+	if !streaming(res) {
+		bodyBytes, res.Body = peekBody(res.Body, maxLogBody)
+	}
 	var bodyText string
 	if res.Header.Get("Content-Encoding") == "gzip" {
-		bodyText = string(tryUnzip(bytes.NewBuffer(bodyBytes)))
+		bodyText = string(tryUnzip(bytes.NewReader(bodyBytes)))
 	} else {
 		bodyText = string(bodyBytes)
 	}
+	if len(bodyText) > maxLogBody {
+		bodyText = bodyText[:maxLogBody]
+	}
 	headerText, err := json.Marshal(res.Header)
 	if err != nil {
 		return
@@ -131,23 +378,25 @@ func safetyLogResponse(ctx *ProxyCtx, res *http.Response) {
 }
 
 func HttpRequestHandle(ctx *ProxyCtx, w http.ResponseWriter) {
+	defer ctx.releaseProxyAddr()
 	req, err := copyRequest(ctx.Req)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	// 检查是否需要代理
-	var proxyUrl *url.URL
-	if checkHostnameNeedProxy(ctx) {
-		pl, err := getProxyUrl(ctx)
-		if err != nil {
-			ctx.Debug(fmt.Sprintf("当前远程代理不可用，降级为本地请求: %s", err))
-		} else {
-			proxyUrl = pl
-		}
-	}
+	// 客户端的认证信息等逐跳头部不属于目标站点, 上游认证由代理地址携带
+	ctx.headers.outgoing(req.Header, ctx.Req)
+	ctx.route = routeDirect
+	defer func() {
+		requestsTotal.Inc(modeHttp, ctx.route)
+	}()
 	safetyLogRequest(ctx, req)
-	res, err := safetyHttpProxyRequest(ctx, req, proxyUrl)
+	res, err := safetyHttpProxyRequest(ctx, req)
+	if errors.Is(err, errProxyUnavailable) {
+		ctx.Warn(fmt.Sprintf("请求失败: %s", err))
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
 	if err != nil {
 		ctx.Warn(fmt.Sprintf("请求失败: %s", err))
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -155,11 +404,19 @@ func HttpRequestHandle(ctx *ProxyCtx, w http.ResponseWriter) {
 	}
 	defer res.Body.Close()
 	safetyLogResponse(ctx, res)
+	ctx.headers.incoming(res.Header, res)
 	for k, vv := range res.Header {
 		for _, v := range vv {
 			w.Header().Add(k, v)
 		}
 	}
 	w.WriteHeader(res.StatusCode)
-	io.Copy(w, res.Body)
+	var dst io.Writer = w
+	if streaming(res) {
+		// 流式响应先发送响应头, 之后每次写入都立即发送
+		rc := http.NewResponseController(w)
+		rc.Flush()
+		dst = &flushWriter{w: w, rc: rc}
+	}
+	io.Copy(dst, res.Body)
 }
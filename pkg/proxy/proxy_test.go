@@ -0,0 +1,617 @@
+package proxy
+
+import (
+	"bufio"
+	"easy-http-proxy-pool/pkg/conf"
+	"easy-http-proxy-pool/pkg/metrics"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestServer 创建测试用的代理服务, 测试结束后关闭
+func newTestServer(t *testing.T, config *conf.Config) *ProxyServer {
+	t.Helper()
+	s, err := NewProxyServer(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+	return s
+}
+
+// newUpstream 模拟上游 http 代理, 普通 http 请求交给 handler 处理, 返回代理地址
+func newUpstream(t *testing.T, handler http.HandlerFunc) string {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	return srv.Listener.Addr().String()
+}
+
+// newBrokenUpstream 模拟不可用的上游代理, 接受连接后立即关闭, 返回代理地址与连接次数
+func newBrokenUpstream(t *testing.T) (string, *atomic.Int32) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	var accepts atomic.Int32
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			accepts.Add(1)
+			conn.Close()
+		}
+	}()
+	return l.Addr().String(), &accepts
+}
+
+// newCountingUpstream 模拟可用的上游代理, 返回 ok 与代理地址、请求次数
+func newCountingUpstream(t *testing.T) (string, *atomic.Int32) {
+	t.Helper()
+	var hits atomic.Int32
+	addr := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		io.Copy(io.Discard, r.Body)
+		io.WriteString(w, "ok")
+	})
+	return addr, &hits
+}
+
+// newConnectUpstream 模拟支持 CONNECT 的上游代理, 隧道建立后原样返回收到的数据
+func newConnectUpstream(t *testing.T) (string, *atomic.Int32) {
+	t.Helper()
+	var hits atomic.Int32
+	addr := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+		io.Copy(conn, conn)
+	})
+	return addr, &hits
+}
+
+// connect 通过代理服务发起 CONNECT 请求, 返回响应与隧道连接
+func connect(t *testing.T, s http.Handler, target string) (*http.Response, net.Conn) {
+	t.Helper()
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(conn, "CONNECT "+target+" HTTP/1.1\r\nHost: "+target+"\r\n\r\n")
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, &bufferedConn{Conn: conn, r: br}
+}
+
+// fixedSources 使用固定地址的代理源
+func fixedSources(addrs ...string) []*conf.ProxySource {
+	return []*conf.ProxySource{{Name: "up", Type: "fixed", TTL: time.Minute, FixedAddr: addrs}}
+}
+
+// serve 通过代理服务发起普通 http 请求
+func serve(s *ProxyServer, method string, target string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+	return rec
+}
+
+func TestDetectBan(t *testing.T) {
+	var direct atomic.Int32
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		direct.Add(1)
+		io.WriteString(w, "direct")
+	}))
+	defer target.Close()
+	banPage := func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "please solve captcha")
+	}
+	t.Run("所有地址都被封禁时返回封禁页面, 不降级直连", func(t *testing.T) {
+		s := newTestServer(t, &conf.Config{
+			ProxySources: fixedSources(newUpstream(t, banPage), newUpstream(t, banPage)),
+			Retry:        &conf.Retry{MaxAttempts: 3},
+			Rules: []*conf.Rule{{
+				Host:   "127.0.0.1",
+				Detect: &conf.Detect{Body: "captcha", Retry: true},
+			}},
+		})
+		rec := serve(s, http.MethodGet, target.URL)
+		if rec.Code != http.StatusOK || rec.Body.String() != "please solve captcha" {
+			t.Fatal(rec.Code, rec.Body.String())
+		}
+		if direct.Load() != 0 {
+			t.Fatal("request should not fall back to direct")
+		}
+	})
+	t.Run("fail 策略下同样返回封禁页面", func(t *testing.T) {
+		s := newTestServer(t, &conf.Config{
+			ProxySources: fixedSources(newUpstream(t, banPage)),
+			Retry:        &conf.Retry{MaxAttempts: 3},
+			Rules: []*conf.Rule{{
+				Host:     "127.0.0.1",
+				Fallback: "fail",
+				Detect:   &conf.Detect{Body: "captcha", Retry: true},
+			}},
+		})
+		rec := serve(s, http.MethodGet, target.URL)
+		if rec.Code != http.StatusOK || rec.Body.String() != "please solve captcha" {
+			t.Fatal(rec.Code, rec.Body.String())
+		}
+	})
+	t.Run("更换地址后返回正常响应", func(t *testing.T) {
+		s := newTestServer(t, &conf.Config{
+			Strategy: "first",
+			ProxySources: fixedSources(newUpstream(t, banPage), newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, "ok")
+			})),
+			Rules: []*conf.Rule{{
+				Host:   "127.0.0.1",
+				Detect: &conf.Detect{Body: "captcha", Retry: true},
+			}},
+		})
+		if rec := serve(s, http.MethodGet, target.URL); rec.Body.String() != "ok" {
+			t.Fatal(rec.Code, rec.Body.String())
+		}
+	})
+	t.Run("只有检测跳转地址时才不跟随跳转", func(t *testing.T) {
+		upstream := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/r" {
+				http.Redirect(w, r, "/ok", http.StatusFound)
+				return
+			}
+			io.WriteString(w, "ok")
+		})
+		newServer := func(detect *conf.Detect) *ProxyServer {
+			return newTestServer(t, &conf.Config{
+				ProxySources: fixedSources(upstream),
+				Rules:        []*conf.Rule{{Host: "127.0.0.1", Detect: detect}},
+			})
+		}
+		if rec := serve(newServer(nil), http.MethodGet, target.URL+"/r"); rec.Body.String() != "ok" {
+			t.Fatal(rec.Code, rec.Body.String())
+		}
+		rec := serve(newServer(&conf.Detect{Location: "^/sorry/"}), http.MethodGet, target.URL+"/r")
+		if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/ok" {
+			t.Fatal(rec.Code, rec.Header())
+		}
+	})
+}
+
+func TestRetry(t *testing.T) {
+	const target = "http://example.test/"
+	newServer := func(retry *conf.Retry, addrs ...string) *ProxyServer {
+		return newTestServer(t, &conf.Config{
+			Strategy:     "first",
+			ProxySources: fixedSources(addrs...),
+			Retry:        retry,
+			Rules:        []*conf.Rule{{Domain: "example.test", Fallback: "fail"}},
+		})
+	}
+	t.Run("幂等请求失败后更换代理重试", func(t *testing.T) {
+		broken, accepts := newBrokenUpstream(t)
+		good, hits := newCountingUpstream(t)
+		s := newServer(nil, broken, good)
+		if rec := serve(s, http.MethodGet, target); rec.Code != http.StatusOK || rec.Body.String() != "ok" {
+			t.Fatal(rec.Code, rec.Body.String())
+		}
+		if accepts.Load() != 1 || hits.Load() != 1 {
+			t.Fatal(accepts.Load(), hits.Load())
+		}
+	})
+	t.Run("非幂等请求只尝试一次", func(t *testing.T) {
+		broken, _ := newBrokenUpstream(t)
+		good, hits := newCountingUpstream(t)
+		s := newServer(&conf.Retry{MaxAttempts: 3}, broken, good)
+		if rec := serve(s, http.MethodPost, target); rec.Code != http.StatusBadGateway {
+			t.Fatal(rec.Code, rec.Body.String())
+		}
+		if hits.Load() != 0 {
+			t.Fatal("post should not be retried")
+		}
+	})
+	t.Run("retry-other-proxy 规则下非幂等请求只在发出前失败时重试", func(t *testing.T) {
+		newRetryServer := func(addrs ...string) *ProxyServer {
+			return newTestServer(t, &conf.Config{
+				Strategy:     "first",
+				ProxySources: fixedSources(addrs...),
+				Rules:        []*conf.Rule{{Domain: "example.test", Fallback: "retry-other-proxy", MaxAttempts: 3}},
+			})
+		}
+		// 无法连接的地址, 请求尚未发出
+		l, _ := net.Listen("tcp", "127.0.0.1:0")
+		refused := l.Addr().String()
+		l.Close()
+		good, hits := newCountingUpstream(t)
+		s := newRetryServer(refused, good)
+		if rec := serve(s, http.MethodPost, target); rec.Code != http.StatusOK || hits.Load() != 1 {
+			t.Fatal(rec.Code, hits.Load())
+		}
+		// 上游收到请求后断开, 请求可能已被处理
+		var received atomic.Int32
+		dropped := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+			received.Add(1)
+			io.Copy(io.Discard, r.Body)
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+		})
+		good, hits = newCountingUpstream(t)
+		s = newRetryServer(dropped, good)
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, target, strings.NewReader("order")))
+		if rec.Code != http.StatusBadGateway || received.Load() != 1 || hits.Load() != 0 {
+			t.Fatal(rec.Code, received.Load(), hits.Load())
+		}
+		// 幂等请求照常重试
+		if rec := serve(s, http.MethodGet, target); rec.Code != http.StatusOK || hits.Load() != 1 {
+			t.Fatal(rec.Code, hits.Load())
+		}
+	})
+	t.Run("超过总耗时上限后停止重试", func(t *testing.T) {
+		slow := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(300 * time.Millisecond)
+		})
+		good, hits := newCountingUpstream(t)
+		s := newServer(&conf.Retry{MaxAttempts: 3, Deadline: 100 * time.Millisecond}, slow, good)
+		start := time.Now()
+		if rec := serve(s, http.MethodGet, target); rec.Code != http.StatusBadGateway {
+			t.Fatal(rec.Code, rec.Body.String())
+		}
+		if hits.Load() != 0 || time.Since(start) > 250*time.Millisecond {
+			t.Fatal(hits.Load(), time.Since(start))
+		}
+	})
+	t.Run("同一地址只尝试一次", func(t *testing.T) {
+		broken, accepts := newBrokenUpstream(t)
+		s := newServer(&conf.Retry{MaxAttempts: 3}, broken)
+		if rec := serve(s, http.MethodGet, target); rec.Code != http.StatusBadGateway {
+			t.Fatal(rec.Code, rec.Body.String())
+		}
+		if accepts.Load() != 1 {
+			t.Fatal(accepts.Load())
+		}
+	})
+	t.Run("请求体过大时不重试", func(t *testing.T) {
+		broken, _ := newBrokenUpstream(t)
+		good, hits := newCountingUpstream(t)
+		s := newServer(&conf.Retry{MaxAttempts: 3}, broken, good)
+		body := strings.Repeat("a", maxReplayBody+1)
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, target, strings.NewReader(body)))
+		if rec.Code != http.StatusBadGateway || hits.Load() != 0 {
+			t.Fatal(rec.Code, hits.Load())
+		}
+		// 可重放的请求体正常重试
+		rec = httptest.NewRecorder()
+		s.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, target, strings.NewReader("small")))
+		if rec.Code != http.StatusOK || hits.Load() != 1 {
+			t.Fatal(rec.Code, hits.Load())
+		}
+	})
+}
+
+func TestUpstreamAuthFailure(t *testing.T) {
+	newServer := func(addrs ...string) *ProxyServer {
+		return newTestServer(t, &conf.Config{
+			Strategy:     "first",
+			ProxySources: fixedSources(addrs...),
+			Rules:        []*conf.Rule{{Domain: "example.test", Fallback: "fail"}},
+		})
+	}
+	// 上游代理总是要求认证, 普通请求与 CONNECT 都返回 407
+	unauthorized := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Proxy-Authenticate", `Basic realm="vendor"`)
+		w.WriteHeader(http.StatusProxyAuthRequired)
+	}
+	t.Run("上游返回 407 时移除地址并更换地址重试", func(t *testing.T) {
+		good, hits := newCountingUpstream(t)
+		s := newServer(newUpstream(t, unauthorized), good)
+		if rec := serve(s, http.MethodGet, "http://example.test/"); rec.Code != http.StatusOK || rec.Body.String() != "ok" {
+			t.Fatal(rec.Code, rec.Body.String())
+		}
+		if hits.Load() != 1 || s.Pools().Default().Size() != 1 {
+			t.Fatal(hits.Load(), s.Pools().Default().Size())
+		}
+	})
+	t.Run("上游的 407 不返回给客户端", func(t *testing.T) {
+		s := newServer(newUpstream(t, unauthorized), newUpstream(t, unauthorized))
+		if rec := serve(s, http.MethodGet, "http://example.test/"); rec.Code != http.StatusBadGateway {
+			t.Fatal(rec.Code, rec.Body.String())
+		}
+		if s.Pools().Default().Size() != 0 {
+			t.Fatal("addresses should be evicted")
+		}
+	})
+	t.Run("https 目标的 CONNECT 返回 407 时移除地址", func(t *testing.T) {
+		s := newServer(newUpstream(t, unauthorized), newUpstream(t, unauthorized))
+		if rec := serve(s, http.MethodGet, "https://example.test/"); rec.Code != http.StatusBadGateway {
+			t.Fatal(rec.Code, rec.Body.String())
+		}
+		if s.Pools().Default().Size() != 0 {
+			t.Fatal("addresses should be evicted")
+		}
+	})
+	t.Run("https 目标的 CONNECT 返回 403 时保留地址", func(t *testing.T) {
+		s := newServer(newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusForbidden)
+		}))
+		if rec := serve(s, http.MethodGet, "https://example.test/"); rec.Code != http.StatusBadGateway {
+			t.Fatal(rec.Code, rec.Body.String())
+		}
+		states := s.Pools().Default().Addresses()
+		if len(states) != 1 || states[0].Breaker != "closed" {
+			t.Fatal(states)
+		}
+	})
+}
+
+func TestFallback(t *testing.T) {
+	newServer := func(r *conf.Rule, addrs ...string) *ProxyServer {
+		r.Domain = "example.test"
+		return newTestServer(t, &conf.Config{
+			Strategy:     "first",
+			ProxySources: fixedSources(addrs...),
+			Rules:        []*conf.Rule{r},
+		})
+	}
+	t.Run("fail 策略下代理不可用时返回 502", func(t *testing.T) {
+		broken, _ := newBrokenUpstream(t)
+		s := newServer(&conf.Rule{Fallback: "fail"}, broken)
+		if rec := serve(s, http.MethodGet, "http://example.test/"); rec.Code != http.StatusBadGateway {
+			t.Fatal(rec.Code, rec.Body.String())
+		}
+		if resp, _ := connect(t, s, "example.test:443"); resp.StatusCode != http.StatusBadGateway {
+			t.Fatal(resp.Status)
+		}
+	})
+	t.Run("retry-other-proxy 最多尝试 maxAttempts 个地址", func(t *testing.T) {
+		var addrs []string
+		var counters []*atomic.Int32
+		for range 3 {
+			addr, accepts := newBrokenUpstream(t)
+			addrs = append(addrs, addr)
+			counters = append(counters, accepts)
+		}
+		good, hits := newCountingUpstream(t)
+		s := newServer(&conf.Rule{Fallback: "retry-other-proxy", MaxAttempts: 3}, append(addrs, good)...)
+		if rec := serve(s, http.MethodGet, "http://example.test/"); rec.Code != http.StatusBadGateway {
+			t.Fatal(rec.Code, rec.Body.String())
+		}
+		for i, accepts := range counters {
+			if accepts.Load() != 1 {
+				t.Fatal(i, accepts.Load())
+			}
+		}
+		if hits.Load() != 0 {
+			t.Fatal("should stop after max attempts")
+		}
+		if resp, _ := connect(t, s, "example.test:443"); resp.StatusCode != http.StatusBadGateway {
+			t.Fatal(resp.Status)
+		}
+		for i, accepts := range counters {
+			if accepts.Load() != 2 {
+				t.Fatal(i, accepts.Load())
+			}
+		}
+	})
+	t.Run("retry-other-proxy 更换地址后成功", func(t *testing.T) {
+		broken, _ := newBrokenUpstream(t)
+		good, hits := newConnectUpstream(t)
+		s := newServer(&conf.Rule{Fallback: "retry-other-proxy", MaxAttempts: 2}, broken, good)
+		resp, conn := connect(t, s, "example.test:443")
+		if resp.StatusCode != http.StatusOK || hits.Load() != 1 {
+			t.Fatal(resp.Status, hits.Load())
+		}
+		io.WriteString(conn, "ping")
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+			t.Fatal(string(buf), err)
+		}
+	})
+}
+
+func TestAuth(t *testing.T) {
+	var forwarded atomic.Value
+	upstream := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		forwarded.Store(r.Header.Get("Proxy-Authorization"))
+		io.WriteString(w, "ok")
+	})
+	s := newTestServer(t, &conf.Config{
+		ProxySources: fixedSources(upstream),
+		Auth:         &conf.Auth{Users: map[string]string{"alice": "secret"}},
+		Rules:        []*conf.Rule{{Domain: "example.test", Fallback: "fail"}},
+	})
+	request := func(username string, password string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "http://example.test/", nil)
+		if username != "" {
+			req.SetBasicAuth(username, password)
+			req.Header.Set("Proxy-Authorization", req.Header.Get("Authorization"))
+			req.Header.Del("Authorization")
+		}
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		return rec
+	}
+	t.Run("未认证或认证失败返回 407", func(t *testing.T) {
+		for _, rec := range []*httptest.ResponseRecorder{request("", ""), request("alice", "wrong"), request("bob", "secret")} {
+			if rec.Code != http.StatusProxyAuthRequired || rec.Header().Get("Proxy-Authenticate") != `Basic realm="proxy"` {
+				t.Fatal(rec.Code, rec.Header())
+			}
+		}
+		if forwarded.Load() != nil {
+			t.Fatal("unauthenticated request should not be forwarded")
+		}
+		if resp, _ := connect(t, s, "example.test:443"); resp.StatusCode != http.StatusProxyAuthRequired || resp.Header.Get("Proxy-Authenticate") == "" {
+			t.Fatal(resp.Status, resp.Header)
+		}
+	})
+	t.Run("认证成功后转发, 不转发客户端的认证信息", func(t *testing.T) {
+		for _, username := range []string{"alice", "alice-session-abc"} {
+			rec := request(username, "secret")
+			if rec.Code != http.StatusOK || rec.Body.String() != "ok" {
+				t.Fatal(username, rec.Code, rec.Body.String())
+			}
+			if v := forwarded.Load(); v != "" {
+				t.Fatal("client credentials forwarded", v)
+			}
+		}
+	})
+}
+
+func TestUpstreamCredentials(t *testing.T) {
+	const vendorAuth = "Basic dmVuZG9yOnB3" // vendor:pw
+	var received atomic.Value
+	// 上游代理要求认证, 记录收到的认证头
+	upstream := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		received.Store(r.Header.Values("Proxy-Authorization"))
+		if r.Header.Get("Proxy-Authorization") != vendorAuth {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		if r.Method != http.MethodConnect {
+			io.WriteString(w, "ok")
+			return
+		}
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		defer conn.Close()
+		io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+		io.Copy(conn, conn)
+	})
+	newServer := func(source *conf.ProxySource) *ProxyServer {
+		source.Name, source.Type, source.TTL = "up", "fixed", time.Minute
+		return newTestServer(t, &conf.Config{
+			ProxySources: []*conf.ProxySource{source},
+			Auth:         &conf.Auth{Users: map[string]string{"alice": "secret"}},
+			Rules:        []*conf.Rule{{Domain: "example.test", Fallback: "fail"}},
+		})
+	}
+	clientAuth := "Basic " + base64.StdEncoding.EncodeToString([]byte("alice:secret"))
+	t.Run("普通 http 请求使用代理源配置的认证信息", func(t *testing.T) {
+		s := newServer(&conf.ProxySource{FixedAddr: []string{upstream}, Username: "vendor", Password: "pw"})
+		req := httptest.NewRequest(http.MethodGet, "http://example.test/", nil)
+		req.Header.Set("Proxy-Authorization", clientAuth)
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK || rec.Body.String() != "ok" {
+			t.Fatal(rec.Code, rec.Body.String())
+		}
+		if got := received.Load().([]string); len(got) != 1 || got[0] != vendorAuth {
+			t.Fatal(got)
+		}
+	})
+	t.Run("CONNECT 使用地址携带的认证信息, 不转发客户端的认证信息", func(t *testing.T) {
+		s := newServer(&conf.ProxySource{FixedAddr: []string{"vendor:pw@" + upstream}})
+		srv := httptest.NewServer(s)
+		defer srv.Close()
+		conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		io.WriteString(conn, "CONNECT example.test:443 HTTP/1.1\r\nHost: example.test:443\r\nProxy-Authorization: "+clientAuth+"\r\n\r\n")
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatal(resp, err)
+		}
+		if got := received.Load().([]string); len(got) != 1 || got[0] != vendorAuth {
+			t.Fatal(got)
+		}
+	})
+}
+
+func TestStreamingResponse(t *testing.T) {
+	release := make(chan struct{})
+	upstream := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: 1\n\n")
+		w.(http.Flusher).Flush()
+		// 第二个事件要等客户端收到第一个事件后才发送
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	})
+	s := newTestServer(t, &conf.Config{
+		ProxySources: fixedSources(upstream),
+		Rules:        []*conf.Rule{{Domain: "example.test", Fallback: "fail"}},
+	})
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	// 先让上游结束响应, 代理服务才能关闭
+	defer close(release)
+	proxyURL, _ := url.Parse(srv.URL)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}, Timeout: 5 * time.Second}
+	resp, err := client.Get("http://example.test/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil || line != "data: 1\n" {
+		t.Fatal(line, err)
+	}
+}
+
+func TestUpstreamDialMetrics(t *testing.T) {
+	// count 读取上游连接耗时的统计次数
+	count := func(result string) int {
+		var buf strings.Builder
+		metrics.DefaultRegistry.Write(&buf)
+		prefix := `proxy_upstream_dial_seconds_count{result="` + result + `"} `
+		for _, line := range strings.Split(buf.String(), "\n") {
+			if v, ok := strings.CutPrefix(line, prefix); ok {
+				n, _ := strconv.Atoi(v)
+				return n
+			}
+		}
+		return 0
+	}
+	t.Run("普通 http 请求统计上游连接耗时", func(t *testing.T) {
+		upstream, _ := newCountingUpstream(t)
+		s := newTestServer(t, &conf.Config{
+			ProxySources: fixedSources(upstream),
+			Rules:        []*conf.Rule{{Domain: "example.test", Fallback: "fail"}},
+		})
+		before := count("success")
+		if rec := serve(s, http.MethodGet, "http://example.test/"); rec.Code != http.StatusOK {
+			t.Fatal(rec.Code)
+		}
+		if got := count("success"); got != before+1 {
+			t.Fatal(before, got)
+		}
+	})
+	t.Run("连接上游失败时统计为失败", func(t *testing.T) {
+		s := newTestServer(t, &conf.Config{
+			ProxySources: fixedSources("127.0.0.1:1"),
+			Rules:        []*conf.Rule{{Domain: "example.test", Fallback: "fail"}},
+		})
+		before := count("failure")
+		serve(s, http.MethodGet, "http://example.test/")
+		if got := count("failure"); got <= before {
+			t.Fatal(before, got)
+		}
+	})
+}
@@ -3,33 +3,135 @@ package proxy
 import (
 	"context"
 	"crypto/tls"
+	"easy-http-proxy-pool/pkg/auth"
 	"easy-http-proxy-pool/pkg/conf"
+	"easy-http-proxy-pool/pkg/metrics"
 	"easy-http-proxy-pool/pkg/middleware"
+	"easy-http-proxy-pool/pkg/mitm"
 	"easy-http-proxy-pool/pkg/pool"
+	"easy-http-proxy-pool/pkg/rule"
 	"errors"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
 
+// serverState 由配置构建的运行时状态, 重新加载时整体替换
+// serverState.sessionHeader 客户端传递会话标识的请求头
+// serverState.headers 转发头部的处理方式
+// serverState.mitm 解密 HTTPS 使用的 CA, 未配置时为 nil
+type serverState struct {
+	conf          *conf.Config
+	auth          *auth.Authenticator
+	rules         *rule.Engine
+	sessionHeader string
+	headers       *headerPolicy
+	mitm          *mitm.Authority
+}
+
+func newServerState(config *conf.Config) (*serverState, error) {
+	authenticator, err := auth.New(config.Auth)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := rule.Compile(config)
+	if err != nil {
+		return nil, err
+	}
+	headers, err := newHeaderPolicy(config.Forwarded)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkTimeouts(config); err != nil {
+		return nil, err
+	}
+	authority, err := mitm.New(config.MITM)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range config.Rules {
+		if item.MITM && authority == nil {
+			return nil, fmt.Errorf("规则配置了 mitm, 但未配置 CA 证书")
+		}
+	}
+	sessionHeader := pool.DefaultSessionHeader
+	if config.Session != nil && config.Session.Header != "" {
+		sessionHeader = config.Session.Header
+	}
+	return &serverState{
+		conf:          config,
+		auth:          authenticator,
+		rules:         rules,
+		sessionHeader: sessionHeader,
+		headers:       headers,
+		mitm:          authority,
+	}, nil
+}
+
+// ProxyServer 代理服务
+// ProxyServer.state 当前运行时状态, 重新加载时整体替换, 进行中的请求继续使用旧状态
+// ProxyServer.transports 普通 http 请求按上游地址共用的 Transport, 地址从代理池移除时一并移除
+// ProxyServer.socks5 socks5 服务的监听, 由 mu 保护, Close 时关闭
+// ProxyServer.closed 是否已经 Close, 之后启动的 socks5 服务立即退出
 type ProxyServer struct {
-	pool pool.Pool
-	conf *conf.Config
+	pools      *pool.Group
+	transports *transportCache
+	state      atomic.Pointer[serverState]
+	mu         sync.Mutex
+	socks5     net.Listener
+	closed     bool
+}
+
+func NewProxyServer(config *conf.Config) (*ProxyServer, error) {
+	state, err := newServerState(config)
+	if err != nil {
+		return nil, err
+	}
+	pools, err := pool.NewGroup(config)
+	if err != nil {
+		return nil, err
+	}
+	transports := newTransportCache()
+	pools.OnRemove(transports.evict)
+	pools.Start()
+	metrics.NewGaugeFunc("proxy_pool_addresses", "Unexpired addresses cached in all pools.", func() float64 {
+		return float64(pools.Size())
+	})
+	metrics.NewGaugeFunc("proxy_http_transports", "Cached HTTP transports keyed by upstream address.", func() float64 {
+		return float64(transports.size())
+	})
+	s := &ProxyServer{pools: pools, transports: transports}
+	s.state.Store(state)
+	return s, nil
+}
+
+// Pools 默认代理池与命名代理池
+func (s *ProxyServer) Pools() *pool.Group {
+	return s.pools
 }
 
-func NewProxyServer(config *conf.Config) *ProxyServer {
-	return &ProxyServer{
-		pool: pool.NewDynamicPool(config),
-		conf: config,
+// Reload 替换配置, 不影响进行中的连接, 配置无效时继续使用当前配置
+func (s *ProxyServer) Reload(config *conf.Config) {
+	state, err := newServerState(config)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("配置无效, 继续使用当前配置: %s", err))
+		return
 	}
+	if err := s.pools.Reload(config); err != nil {
+		slog.Warn(fmt.Sprintf("代理池配置无效, 继续使用当前配置: %s", err))
+		return
+	}
+	s.state.Store(state)
 }
 
-func (s *ProxyServer) handleConnect(w http.ResponseWriter, r *http.Request) {
-	ctx := &ProxyCtx{Req: r, Pool: s.pool, conf: s.conf}
+func (s *ProxyServer) handleConnect(ctx *ProxyCtx, w http.ResponseWriter) {
 	hij, ok := w.(http.Hijacker)
 	if !ok {
 		panic("httpserver does not support hijacking")
@@ -38,21 +140,143 @@ func (s *ProxyServer) handleConnect(w http.ResponseWriter, r *http.Request) {
 	if e != nil {
 		http.Error(w, e.Error(), http.StatusInternalServerError)
 		ctx.Error(e.Error())
+		return
 	}
 	HijackConnectHandle(ctx, proxyClient)
 }
 
-func (s *ProxyServer) handleHttp(w http.ResponseWriter, r *http.Request) {
-	ctx := &ProxyCtx{Req: r, Pool: s.pool, conf: s.conf}
+func (s *ProxyServer) handleHttp(ctx *ProxyCtx, w http.ResponseWriter) {
 	HttpRequestHandle(ctx, w)
 }
 
+// authenticate 校验客户端的 Proxy-Authorization, 成功时返回去掉会话标识的用户名
+func authenticate(authenticator *auth.Authenticator, r *http.Request) (string, bool) {
+	username, password, ok := auth.ParseProxyAuthorization(r.Header.Get("Proxy-Authorization"))
+	if !ok {
+		return "", false
+	}
+	user, _ := auth.SplitSession(username)
+	if !authenticator.Check(user, password) {
+		return "", false
+	}
+	return user, true
+}
+
+// sessionKey 读取客户端的会话标识, 请求头优先于 user-session-xxx 形式的用户名
+func sessionKey(r *http.Request, header string) string {
+	if key := r.Header.Get(header); key != "" {
+		return key
+	}
+	username, _, ok := auth.ParseProxyAuthorization(r.Header.Get("Proxy-Authorization"))
+	if !ok {
+		return ""
+	}
+	_, session := auth.SplitSession(username)
+	return session
+}
+
+// newRuleTarget 由客户端请求构建规则匹配目标
+func newRuleTarget(r *http.Request) *rule.Target {
+	if r.Method == http.MethodConnect {
+		return rule.NewTarget(r.Host, 443, r.Method, "")
+	}
+	host := r.URL.Host
+	if host == "" {
+		host = r.Host
+	}
+	port := 80
+	if r.URL.Scheme == "https" {
+		port = 443
+	}
+	return rule.NewTarget(host, port, r.Method, r.URL.Path)
+}
+
+// blockConnection 直接断开客户端连接, 无法劫持时返回 403
+func blockConnection(w http.ResponseWriter) {
+	if hij, ok := w.(http.Hijacker); ok {
+		if conn, _, err := hij.Hijack(); err == nil {
+			conn.Close()
+			return
+		}
+	}
+	http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+}
+
+// proxyAuthRequired 返回 407 要求客户端认证
+func proxyAuthRequired(w http.ResponseWriter) {
+	w.Header().Set("Proxy-Authenticate", `Basic realm="proxy"`)
+	http.Error(w, "Proxy Authentication Required", http.StatusProxyAuthRequired)
+}
+
 func (s *ProxyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	state := s.state.Load()
+	var user string
+	if state.auth != nil {
+		username, ok := authenticate(state.auth, r)
+		if !ok {
+			ctx := &ProxyCtx{Req: r}
+			ctx.Info("客户端认证失败", "remoteAddr", r.RemoteAddr)
+			proxyAuthRequired(w)
+			return
+		}
+		user = username
+	}
+	s.route(w, r, state, user, "")
+}
+
+// route 匹配规则并处理已认证的请求
+// session 请求未携带会话标识时使用的会话, 解密后的请求沿用 CONNECT 请求的会话
+func (s *ProxyServer) route(w http.ResponseWriter, r *http.Request, state *serverState, user string, session string) {
+	ctx := s.newProxyCtx(r, state, user, session)
+	mode := modeHttp
 	if r.Method == http.MethodConnect {
-		s.handleConnect(w, r)
+		mode = modeConnect
+	}
+	switch ctx.Rule.Action {
+	case rule.ActionBlock:
+		ctx.Info(fmt.Sprintf("命中规则 %s, 断开连接", ctx.Rule))
+		requestsTotal.Inc(mode, routeBlock)
+		blockConnection(w)
+		return
+	case rule.ActionReject:
+		ctx.Info(fmt.Sprintf("命中规则 %s, 拒绝请求", ctx.Rule))
+		requestsTotal.Inc(mode, routeReject)
+		http.Error(w, http.StatusText(ctx.Rule.Status), ctx.Rule.Status)
+		return
+	}
+	if r.Method == http.MethodConnect && ctx.Rule.MITM && state.mitm != nil {
+		s.handleIntercept(ctx, state, w)
+	} else if r.Method == http.MethodConnect {
+		s.handleConnect(ctx, w)
 	} else {
-		s.handleHttp(w, r)
+		s.handleHttp(ctx, w)
+	}
+}
+
+// newProxyCtx 匹配规则并选择代理池, 构建请求上下文
+func (s *ProxyServer) newProxyCtx(r *http.Request, state *serverState, user string, session string) *ProxyCtx {
+	ctx := &ProxyCtx{Req: r, User: user, conf: state.conf, headers: state.headers, transports: s.transports}
+	ctx.Rule = state.rules.Match(r.Context(), newRuleTarget(r))
+	ctx.timeouts = resolveTimeouts(state.conf.Timeouts, ctx.Rule.Timeouts)
+	p, ok := s.pools.Get(ctx.Rule.Pool)
+	if !ok {
+		// 配置重新加载期间代理池已被移除
+		ctx.Warn(fmt.Sprintf("代理池不存在: %s, 使用默认代理池", ctx.Rule.Pool))
+		p = s.pools.Default()
+	}
+	// 会话标识只对本代理有意义, 不转发给目标站点
+	ctx.Session = sessionKey(r, state.sessionHeader)
+	if ctx.Session == "" {
+		ctx.Session = session
+	}
+	r.Header.Del(state.sessionHeader)
+	ctx.Pool = p.WithSources(ctx.Rule.Sources)
+	if ctx.Session != "" {
+		// 不同用户的同名会话互不影响
+		ctx.Pool = pool.WithSession(ctx.Pool, ctx.User+"/"+ctx.Session)
 	}
+	ctx.Pool = pool.WithAttempts(ctx.Pool)
+	return ctx
 }
 
 func (s *ProxyServer) Listen(addr string) {
@@ -80,4 +304,17 @@ func (s *ProxyServer) Listen(addr string) {
 	if err := server.Shutdown(ctx); err != nil {
 		slog.Error(fmt.Sprintf("Server forced to shutdown: %v\n", err))
 	}
+	s.Close()
+}
+
+// Close 关闭 socks5 监听, 停止代理池的后台任务并关闭空闲连接
+func (s *ProxyServer) Close() {
+	s.mu.Lock()
+	s.closed = true
+	if s.socks5 != nil {
+		s.socks5.Close()
+	}
+	s.mu.Unlock()
+	s.pools.Close()
+	s.transports.closeAll()
 }
@@ -0,0 +1,138 @@
+package proxy
+
+import (
+	"context"
+	"easy-http-proxy-pool/pkg/auth"
+	"easy-http-proxy-pool/pkg/middleware"
+	"easy-http-proxy-pool/pkg/rule"
+	"easy-http-proxy-pool/pkg/socks5"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"time"
+)
+
+// ListenSocks5 在 addr 上提供 socks5 服务, 只支持 CONNECT 命令
+// 与 http CONNECT 使用相同的认证、规则、代理池与隧道
+func (s *ProxyServer) ListenSocks5(addr string) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		slog.Error(fmt.Sprintf("socks5 服务启动失败: %v", err))
+		os.Exit(1)
+	}
+	slog.Info(fmt.Sprintf("socks5 服务启动 %s", addr))
+	s.serveSocks5Listener(l)
+}
+
+// serveSocks5Listener 接受 l 上的 socks5 连接, 直到 l 被 Close 关闭
+func (s *ProxyServer) serveSocks5Listener(l net.Listener) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return
+	}
+	s.socks5 = l
+	s.mu.Unlock()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			slog.Warn(fmt.Sprintf("socks5 服务接受连接失败: %v", err))
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		go s.serveSocks5(conn)
+	}
+}
+
+// socks5Request 由 socks5 请求构建等价的 CONNECT 请求, 用于规则匹配与建立隧道
+func socks5Request(conn net.Conn, target string) (*http.Request, error) {
+	ctx := middleware.WithRequestID(context.Background())
+	r, err := http.NewRequestWithContext(ctx, http.MethodConnect, "", http.NoBody)
+	if err != nil {
+		return nil, err
+	}
+	r.Host = target
+	r.URL.Host = target
+	r.RemoteAddr = conn.RemoteAddr().String()
+	return r, nil
+}
+
+// serveSocks5 处理单个 socks5 连接
+// 用户名支持 user-session-xxx 形式传递会话标识, socks5 请求不解密
+func (s *ProxyServer) serveSocks5(conn net.Conn) {
+	defer func() {
+		if err := recover(); err != nil {
+			slog.Error(fmt.Sprintf("recover error: %v", err), slog.String("stack", string(debug.Stack())))
+			conn.Close()
+		}
+	}()
+	state := s.state.Load()
+	var check func(username string, password string) bool
+	if state.auth != nil {
+		check = func(username string, password string) bool {
+			user, _ := auth.SplitSession(username)
+			return state.auth.Check(user, password)
+		}
+	}
+	conn.SetDeadline(time.Now().Add(resolveTimeouts(state.conf.Timeouts).handshake))
+	target, username, err := socks5.ServerHandshake(conn, check)
+	if err != nil {
+		slog.Debug(fmt.Sprintf("socks5 握手失败 %s: %s", conn.RemoteAddr(), err))
+		conn.Close()
+		return
+	}
+	conn.SetDeadline(time.Time{})
+	r, err := socks5Request(conn, target)
+	if err != nil {
+		socks5.WriteReply(conn, socks5.ReplyGeneralFailure)
+		conn.Close()
+		return
+	}
+	user, session := auth.SplitSession(username)
+	if state.auth == nil {
+		user = ""
+	}
+	ctx := s.newProxyCtx(r, state, user, session)
+	switch ctx.Rule.Action {
+	case rule.ActionBlock:
+		ctx.Info(fmt.Sprintf("命中规则 %s, 断开连接", ctx.Rule))
+		requestsTotal.Inc(modeSocks5, routeBlock)
+		conn.Close()
+		return
+	case rule.ActionReject:
+		ctx.Info(fmt.Sprintf("命中规则 %s, 拒绝请求", ctx.Rule))
+		requestsTotal.Inc(modeSocks5, routeReject)
+		socks5.WriteReply(conn, socks5.ReplyNotAllowed)
+		conn.Close()
+		return
+	}
+	// 等待隧道关闭后再归还代理地址
+	defer ctx.releaseProxyAddr()
+	targetConn, err := dialTarget(ctx)
+	requestsTotal.Inc(modeSocks5, ctx.route)
+	if err != nil {
+		code := byte(socks5.ReplyHostUnreachable)
+		if errors.Is(err, errProxyUnavailable) {
+			code = socks5.ReplyGeneralFailure
+		}
+		ctx.Debug(fmt.Sprintf("连接目标失败: %s", err))
+		socks5.WriteReply(conn, code)
+		conn.Close()
+		return
+	}
+	if err := socks5.WriteReply(conn, socks5.ReplySucceeded); err != nil {
+		conn.Close()
+		targetConn.Close()
+		return
+	}
+	ctx.Debug("隧道建立, 开始正式传输")
+	relayTunnel(ctx, conn, targetConn)
+}
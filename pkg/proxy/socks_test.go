@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"easy-http-proxy-pool/pkg/conf"
+	"easy-http-proxy-pool/pkg/socks5"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// socksConnect 通过 socks5 服务连接 target, 返回握手结果与客户端连接
+func socksConnect(t *testing.T, s *ProxyServer, target string, user *url.Userinfo) (net.Conn, error) {
+	t.Helper()
+	client, server := net.Pipe()
+	t.Cleanup(func() { client.Close() })
+	go s.serveSocks5(server)
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	return client, socks5.ClientHandshake(client, target, user)
+}
+
+func TestSocks5(t *testing.T) {
+	a, hitsA := newConnectUpstream(t)
+	b, hitsB := newConnectUpstream(t)
+	s := newTestServer(t, &conf.Config{
+		ProxySources: []*conf.ProxySource{
+			{Name: "a", Type: "fixed", TTL: time.Minute, FixedAddr: []string{a}},
+			{Name: "b", Type: "fixed", TTL: time.Minute, FixedAddr: []string{b}},
+		},
+		Pools: []*conf.PoolConfig{{Name: "named", Sources: []string{"b"}}},
+		Auth:  &conf.Auth{Users: map[string]string{"alice": "secret"}},
+		Rules: []*conf.Rule{
+			{Domain: "blocked.test", Action: "block"},
+			{Domain: "rejected.test", Action: "reject"},
+			{Domain: "named.test", Pool: "named", Fallback: "fail"},
+		},
+	})
+	user := url.UserPassword("alice-session-abc", "secret")
+	t.Run("认证失败", func(t *testing.T) {
+		if _, err := socksConnect(t, s, "named.test:443", url.UserPassword("alice", "wrong")); !errors.Is(err, socks5.ErrAuthFailed) {
+			t.Fatal(err)
+		}
+	})
+	t.Run("block 规则直接断开连接", func(t *testing.T) {
+		_, err := socksConnect(t, s, "blocked.test:443", user)
+		var replyErr *socks5.ReplyError
+		if err == nil || errors.As(err, &replyErr) {
+			t.Fatal(err)
+		}
+	})
+	t.Run("reject 规则应答不允许连接", func(t *testing.T) {
+		_, err := socksConnect(t, s, "rejected.test:443", user)
+		var replyErr *socks5.ReplyError
+		if !errors.As(err, &replyErr) || replyErr.Code != socks5.ReplyNotAllowed {
+			t.Fatal(err)
+		}
+	})
+	t.Run("使用规则指定的代理池建立隧道并转发数据", func(t *testing.T) {
+		conn, err := socksConnect(t, s, "named.test:443", user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(conn, "ping")
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+			t.Fatal(string(buf), err)
+		}
+		if hitsA.Load() != 0 || hitsB.Load() != 1 {
+			t.Fatal(hitsA.Load(), hitsB.Load())
+		}
+		if p, _ := s.Pools().Get("named"); p.SessionCount() != 1 {
+			t.Fatal("session should be bound in the named pool", p.SessionCount())
+		}
+	})
+}
+
+func TestSocks5Listener(t *testing.T) {
+	s := newTestServer(t, &conf.Config{ProxySources: fixedSources("127.0.0.1:1")})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.serveSocks5Listener(l)
+	}()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	s.Close()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("socks5 listener should stop after Close")
+	}
+	if _, err := net.Dial("tcp", l.Addr().String()); err == nil {
+		t.Fatal("listener should be closed")
+	}
+}
@@ -0,0 +1,150 @@
+package proxy
+
+import (
+	"easy-http-proxy-pool/pkg/conf"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 超时的默认值
+const (
+	defaultDialTimeout      = 4 * time.Second
+	defaultHandshakeTimeout = 10 * time.Second
+	defaultRequestTimeout   = 10 * time.Second
+	defaultIdleTimeout      = 5 * time.Minute
+)
+
+// timeouts 单个请求使用的超时, 由规则、全局配置与默认值合并得到
+type timeouts struct {
+	dial      time.Duration
+	handshake time.Duration
+	request   time.Duration
+	idle      time.Duration
+}
+
+var defaultTimeouts = timeouts{
+	dial:      defaultDialTimeout,
+	handshake: defaultHandshakeTimeout,
+	request:   defaultRequestTimeout,
+	idle:      defaultIdleTimeout,
+}
+
+// checkTimeouts 校验全局与规则的超时配置, 除 idle 外不允许负数, idle 为负数表示不限制
+func checkTimeouts(config *conf.Config) error {
+	check := func(name string, c *conf.Timeouts) error {
+		if c == nil {
+			return nil
+		}
+		if c.Dial < 0 || c.Handshake < 0 || c.Request < 0 {
+			return fmt.Errorf("%s 超时不能为负数", name)
+		}
+		return nil
+	}
+	if err := check("timeouts", config.Timeouts); err != nil {
+		return err
+	}
+	for i, item := range config.Rules {
+		if err := check(fmt.Sprintf("规则 %d", i+1), item.Timeouts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveTimeouts 按顺序合并超时配置, 后面的配置覆盖前面的, 未配置的项使用默认值
+// idle 为负数时关闭空闲超时, 规则可以对单独的目标关闭或重新开启
+func resolveTimeouts(configs ...*conf.Timeouts) timeouts {
+	t := defaultTimeouts
+	for _, c := range configs {
+		if c == nil {
+			continue
+		}
+		if c.Dial > 0 {
+			t.dial = c.Dial
+		}
+		if c.Handshake > 0 {
+			t.handshake = c.Handshake
+		}
+		if c.Request > 0 {
+			t.request = c.Request
+		}
+		if c.Idle != 0 {
+			t.idle = c.Idle
+		}
+	}
+	return t
+}
+
+// idleWatcher 隧道双向都没有数据传输超过 idle 后调用 onIdle
+// idleWatcher.last 最后一次传输数据的时间, 纳秒
+type idleWatcher struct {
+	mu     sync.Mutex
+	idle   time.Duration
+	last   atomic.Int64
+	timer  *time.Timer
+	onIdle func()
+}
+
+// watchIdle 开始监控空闲, idle 不大于 0 时返回 nil, 不做监控
+func watchIdle(idle time.Duration, onIdle func()) *idleWatcher {
+	if idle <= 0 {
+		return nil
+	}
+	w := &idleWatcher{idle: idle, onIdle: onIdle}
+	w.touch()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.timer = time.AfterFunc(idle, w.check)
+	return w
+}
+
+// check 定时器到期时检查空闲时长, 期间有数据传输则按剩余时间重新计时
+func (w *idleWatcher) check() {
+	elapsed := time.Since(time.Unix(0, w.last.Load()))
+	if elapsed < w.idle {
+		w.mu.Lock()
+		w.timer.Reset(w.idle - elapsed)
+		w.mu.Unlock()
+		return
+	}
+	w.onIdle()
+}
+
+func (w *idleWatcher) touch() {
+	w.last.Store(time.Now().UnixNano())
+}
+
+// stop 隧道关闭后停止监控
+func (w *idleWatcher) stop() {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.timer.Stop()
+}
+
+// wrap 返回读取时记录数据传输的 Reader, 未监控时返回 r 本身
+func (w *idleWatcher) wrap(r io.Reader) io.Reader {
+	if w == nil {
+		return r
+	}
+	return &activityReader{Reader: r, watcher: w}
+}
+
+// activityReader 读取到数据时刷新空闲计时
+type activityReader struct {
+	io.Reader
+	watcher *idleWatcher
+}
+
+func (r *activityReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if n > 0 {
+		r.watcher.touch()
+	}
+	return n, err
+}
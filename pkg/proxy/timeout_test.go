@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"easy-http-proxy-pool/pkg/conf"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestResolveTimeouts(t *testing.T) {
+	t.Run("规则覆盖全局配置", func(t *testing.T) {
+		global := &conf.Timeouts{Dial: time.Second, Request: time.Minute}
+		rule := &conf.Timeouts{Request: time.Hour}
+		got := resolveTimeouts(global, rule)
+		want := timeouts{dial: time.Second, handshake: defaultHandshakeTimeout, request: time.Hour, idle: defaultIdleTimeout}
+		if got != want {
+			t.Fatal(got)
+		}
+	})
+	t.Run("未配置时使用默认值", func(t *testing.T) {
+		if got := resolveTimeouts(nil, nil); got != defaultTimeouts {
+			t.Fatal(got)
+		}
+	})
+	t.Run("负数无效", func(t *testing.T) {
+		config := &conf.Config{Rules: []*conf.Rule{{Action: "direct", Timeouts: &conf.Timeouts{Dial: -time.Second}}}}
+		if checkTimeouts(config) == nil {
+			t.Fatal("should fail")
+		}
+	})
+	t.Run("idle 为负数时关闭空闲超时, 规则可以重新开启", func(t *testing.T) {
+		global := &conf.Timeouts{Idle: -1}
+		config := &conf.Config{Timeouts: global, Rules: []*conf.Rule{{Action: "direct", Timeouts: &conf.Timeouts{Idle: -1}}}}
+		if err := checkTimeouts(config); err != nil {
+			t.Fatal(err)
+		}
+		if got := resolveTimeouts(global); got.idle > 0 || watchIdle(got.idle, func() {}) != nil {
+			t.Fatal(got)
+		}
+		if got := resolveTimeouts(global, &conf.Timeouts{Idle: time.Minute}); got.idle != time.Minute {
+			t.Fatal(got)
+		}
+	})
+}
+
+func TestIdleWatcher(t *testing.T) {
+	t.Run("有数据传输时不关闭", func(t *testing.T) {
+		client, server := net.Pipe()
+		idle := watchIdle(100*time.Millisecond, func() { client.Close() })
+		defer idle.stop()
+		go func() {
+			for i := 0; i < 5; i++ {
+				server.Write([]byte("x"))
+				time.Sleep(50 * time.Millisecond)
+			}
+			server.Close()
+		}()
+		data, _ := io.ReadAll(idle.wrap(client))
+		if string(data) != "xxxxx" {
+			t.Fatal(string(data))
+		}
+	})
+	t.Run("空闲超时后关闭", func(t *testing.T) {
+		client, server := net.Pipe()
+		defer server.Close()
+		idle := watchIdle(50*time.Millisecond, func() { client.Close() })
+		defer idle.stop()
+		done := make(chan struct{})
+		go func() {
+			io.ReadAll(idle.wrap(client))
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("idle tunnel not closed")
+		}
+	})
+}
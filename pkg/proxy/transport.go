@@ -0,0 +1,114 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// 每个上游 Transport 的空闲连接限制
+const (
+	maxIdleConns        = 64
+	maxIdleConnsPerHost = 8
+	idleConnTimeout     = 90 * time.Second
+)
+
+// directKey 直连使用的 Transport 键
+const directKey = ""
+
+// transportKey Transport 的缓存键, 连接与握手超时不同的规则使用不同的 Transport
+type transportKey struct {
+	addr      string
+	dial      time.Duration
+	handshake time.Duration
+}
+
+// transportCache 按上游代理地址与超时缓存 Transport, 复用到上游的连接
+// 地址从代理池移除后关闭对应 Transport 的空闲连接并移除, 进行中的请求不受影响
+type transportCache struct {
+	mu         sync.Mutex
+	transports map[transportKey]*http.Transport
+}
+
+func newTransportCache() *transportCache {
+	return &transportCache{transports: make(map[transportKey]*http.Transport)}
+}
+
+// get 获取上游地址对应的 Transport, addr 为空时返回直连的 Transport
+// 连接与 TLS 握手使用 t 中的超时
+func (c *transportCache) get(addr string, proxyURL *url.URL, t timeouts) *http.Transport {
+	key := transportKey{addr: addr, dial: t.dial, handshake: t.handshake}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if tr, ok := c.transports[key]; ok {
+		return tr
+	}
+	tr := newTransport(proxyURL, t)
+	c.transports[key] = tr
+	return tr
+}
+
+// evict 移除地址对应的全部 Transport
+func (c *transportCache) evict(addr string) {
+	var evicted []*http.Transport
+	c.mu.Lock()
+	for key, tr := range c.transports {
+		if key.addr == addr {
+			evicted = append(evicted, tr)
+			delete(c.transports, key)
+		}
+	}
+	c.mu.Unlock()
+	for _, tr := range evicted {
+		tr.CloseIdleConnections()
+	}
+}
+
+// closeAll 关闭所有空闲连接
+func (c *transportCache) closeAll() {
+	c.mu.Lock()
+	transports := c.transports
+	c.transports = make(map[transportKey]*http.Transport)
+	c.mu.Unlock()
+	for _, tr := range transports {
+		tr.CloseIdleConnections()
+	}
+}
+
+// size 缓存的 Transport 数量
+func (c *transportCache) size() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.transports)
+}
+
+// newTransport 创建请求使用的 Transport, proxyURL 为空时直连
+// t.dial 为连接上游代理或目标站点的超时, t.handshake 为 TLS 握手(https 上游代理或 https 目标)的超时
+func newTransport(proxyURL *url.URL, t timeouts) *http.Transport {
+	tr := &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout:   t.dial,
+			KeepAlive: 15 * time.Second,
+		}).DialContext,
+		TLSHandshakeTimeout: t.handshake,
+		TLSClientConfig:     &tls.Config{InsecureSkipVerify: true},
+		MaxIdleConns:        maxIdleConns,
+		MaxIdleConnsPerHost: maxIdleConnsPerHost,
+		IdleConnTimeout:     idleConnTimeout,
+	}
+	if proxyURL != nil {
+		tr.Proxy = http.ProxyURL(proxyURL)
+		// https 目标经上游代理 CONNECT, 拒绝时返回可区分原因的错误
+		tr.OnProxyConnectResponse = func(_ context.Context, _ *url.URL, _ *http.Request, resp *http.Response) error {
+			if resp.StatusCode == http.StatusOK {
+				return nil
+			}
+			return connectStatusError(resp)
+		}
+	}
+	return tr
+}
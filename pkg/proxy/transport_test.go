@@ -0,0 +1,37 @@
+package proxy
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestTransportCache(t *testing.T) {
+	t.Run("同一上游共用 Transport", func(t *testing.T) {
+		c := newTransportCache()
+		proxyURL, _ := url.Parse("http://127.0.0.1:8080")
+		tr := c.get("127.0.0.1:8080", proxyURL, defaultTimeouts)
+		if c.get("127.0.0.1:8080", proxyURL, defaultTimeouts) != tr || c.get(directKey, nil, defaultTimeouts) == tr {
+			t.Fatal("unexpected transport")
+		}
+		c.evict("127.0.0.1:8080")
+		if c.size() != 1 || c.get("127.0.0.1:8080", proxyURL, defaultTimeouts) == tr {
+			t.Fatal("transport not evicted")
+		}
+	})
+	t.Run("超时不同的规则使用不同的 Transport", func(t *testing.T) {
+		c := newTransportCache()
+		proxyURL, _ := url.Parse("http://127.0.0.1:8080")
+		custom := defaultTimeouts
+		custom.handshake = 3 * time.Second
+		tr := c.get("127.0.0.1:8080", proxyURL, defaultTimeouts)
+		slow := c.get("127.0.0.1:8080", proxyURL, custom)
+		if slow == tr || slow.TLSHandshakeTimeout != 3*time.Second {
+			t.Fatal("timeouts should not be shared")
+		}
+		c.evict("127.0.0.1:8080")
+		if c.size() != 0 {
+			t.Fatal(c.size())
+		}
+	})
+}
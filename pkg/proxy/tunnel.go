@@ -1,31 +1,40 @@
 package proxy
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"easy-http-proxy-pool/pkg/pool"
+	"easy-http-proxy-pool/pkg/rule"
+	"easy-http-proxy-pool/pkg/socks5"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
-	"regexp"
-	"strings"
+	"net/url"
 	"sync"
 	"time"
 )
 
-func copyOrWarn(ctx *ProxyCtx, dst io.Writer, src io.Reader, wg *sync.WaitGroup) {
-	if _, err := io.Copy(dst, src); err != nil {
+func copyOrWarn(ctx *ProxyCtx, dst io.Writer, src io.Reader, idle *idleWatcher, direction string, wg *sync.WaitGroup) {
+	n, err := io.Copy(dst, idle.wrap(src))
+	if err != nil {
 		ctx.Debug(fmt.Sprintf("Error copying to client: %s", err))
 	}
+	tunnelBytesTotal.Add(float64(n), direction)
 	wg.Done()
 }
 
-func copyAndClose(ctx *ProxyCtx, dst, src halfClosable) {
-	if _, err := io.Copy(dst, src); err != nil {
+func copyAndClose(ctx *ProxyCtx, dst, src halfClosable, idle *idleWatcher, direction string, wg *sync.WaitGroup) {
+	n, err := io.Copy(dst, idle.wrap(src))
+	if err != nil {
 		ctx.Debug(fmt.Sprintf("Error copying to client: %s", err))
 	}
+	tunnelBytesTotal.Add(float64(n), direction)
 	dst.CloseWrite()
 	src.CloseRead()
+	wg.Done()
 }
 
 // httpError 错误处理
@@ -56,12 +65,17 @@ type halfClosable interface {
 // createHttpConnectBytes 重新构造HTTP CONNECT请求报文
 // CONNECT xxx.com:443 HTTP/1.1
 // Host: xxx.com:443
+// Proxy-Authorization: Basic xxx (上游代理需要认证时)
 // ....
-func createHttpConnectBytes(req *http.Request) []byte {
+// header 为已按转发规则处理过的请求头, 多值头部全部转发
+func createHttpConnectBytes(req *http.Request, header http.Header, user *url.Userinfo) []byte {
 	reqByte := []byte(fmt.Sprintf("%s %s %s\r\n", req.Method, req.Host, req.Proto))
 	reqByte = concat(reqByte, []byte(fmt.Sprintf("Host: %s\r\n", req.Host)))
-	for k, v := range req.Header {
-		reqByte = concat(reqByte, []byte(fmt.Sprintf("%s: %s\r\n", k, v[0])))
+	var headerBuf bytes.Buffer
+	header.Write(&headerBuf)
+	reqByte = concat(reqByte, headerBuf.Bytes())
+	if user != nil {
+		reqByte = concat(reqByte, []byte(fmt.Sprintf("Proxy-Authorization: %s\r\n", pool.ProxyAuthorization(user))))
 	}
 	reqByte = concat(reqByte, []byte{13, 10})
 	all, err := io.ReadAll(req.Body)
@@ -71,118 +85,248 @@ func createHttpConnectBytes(req *http.Request) []byte {
 	return reqByte
 }
 
-// checkProxyConnectTunnel 检查代理隧道连接
-// tcp 连接成功后，返回 HTTP/1.1 200 Connection established 隧道成功建立
-func checkProxyConnectTunnel(conn net.Conn) error {
-	var buf [1024]byte
-	_, err := conn.Read(buf[:])
+// 上游代理拒绝建立隧道
+var (
+	errUpstreamAuth      = errors.New("上游代理认证失败")
+	errUpstreamForbidden = errors.New("上游代理拒绝访问")
+	errUpstreamRejected  = errors.New("上游代理拒绝建立隧道")
+)
+
+// bufferedConn 读取时先返回握手时已读入缓冲区的数据
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// checkProxyConnectTunnel 读取上游代理对 CONNECT 的响应, 任意 2xx 表示隧道建立
+// 响应头之后已读入缓冲区的数据不会丢失, 返回的连接会先读到这部分数据
+func checkProxyConnectTunnel(conn net.Conn, req *http.Request) (net.Conn, error) {
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("响应报文不符合预期: %w", err)
+	}
+	if resp.StatusCode/100 == 2 {
+		if br.Buffered() > 0 {
+			return &bufferedConn{Conn: conn, r: br}, nil
+		}
+		return conn, nil
 	}
-	if strings.HasPrefix(string(buf[:]), "HTTP/1.1 200 Connection established") {
-		return nil
+	resp.Body.Close()
+	return nil, connectStatusError(resp)
+}
+
+// connectStatusError 上游代理拒绝 CONNECT 时按状态码区分原因
+func connectStatusError(resp *http.Response) error {
+	switch resp.StatusCode {
+	case http.StatusProxyAuthRequired:
+		return fmt.Errorf("%w: %s", errUpstreamAuth, resp.Status)
+	case http.StatusForbidden:
+		return fmt.Errorf("%w: %s", errUpstreamForbidden, resp.Status)
 	}
-	if strings.HasPrefix(string(buf[:]), "HTTP/1.0 200 Connection established") {
-		return nil
+	return fmt.Errorf("%w: %s", errUpstreamRejected, resp.Status)
+}
+
+// isTimeout 连接、握手或等待响应超时
+func isTimeout(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
 	}
-	return fmt.Errorf("响应报文不符合预期: %s", string(bytes.TrimSpace(buf[:])))
+	return errors.Is(err, context.DeadlineExceeded)
+}
+
+// failureReason 隧道建立失败的原因
+func failureReason(err error) string {
+	var replyErr *socks5.ReplyError
+	switch {
+	case isTimeout(err):
+		return pool.FailureTimeout
+	case errors.Is(err, errUpstreamAuth), errors.Is(err, socks5.ErrAuthFailed):
+		return pool.FailureAuth
+	case errors.Is(err, errUpstreamForbidden):
+		return pool.FailureForbidden
+	case errors.As(err, &replyErr) && replyErr.Code == socks5.ReplyNotAllowed:
+		return pool.FailureForbidden
+	case errors.Is(err, errUpstreamRejected), errors.As(err, &replyErr):
+		return pool.FailureRejected
+	}
+	return pool.FailureHandshake
 }
 
 // tcpConnect 发起tcp连接
-func tcpConnect(ctx context.Context, addr string) (net.Conn, error) {
+func tcpConnect(ctx context.Context, addr string, timeout time.Duration) (net.Conn, error) {
 	dialContext := (&net.Dialer{
-		Timeout:   4 * time.Second,
+		Timeout:   timeout,
 		KeepAlive: 15 * time.Second,
 	}).DialContext
 	return dialContext(ctx, "tcp", addr)
 }
 
+// handshakeHttpProxy 通过 HTTP CONNECT 建立隧道
+func handshakeHttpProxy(ctx *ProxyCtx, conn net.Conn, proxyURL *url.URL) (net.Conn, error) {
+	// 客户端的认证信息等逐跳头部不属于上游代理, 上游认证由代理地址携带
+	header := ctx.Req.Header.Clone()
+	ctx.headers.outgoing(header, ctx.Req)
+	reqBytes := createHttpConnectBytes(ctx.Req, header, proxyURL.User)
+	if _, err := conn.Write(reqBytes); err != nil {
+		return nil, err
+	}
+	return checkProxyConnectTunnel(conn, ctx.Req)
+}
+
+// handshakeSocks5Proxy 通过 socks5 建立隧道, socks5 协议在本地解析域名
+func handshakeSocks5Proxy(ctx *ProxyCtx, conn net.Conn, proxyURL *url.URL) error {
+	target := ctx.Req.Host
+	if proxyURL.Scheme == pool.ProtocolSocks5 {
+		resolved, err := socks5.ResolveTarget(ctx.Req.Context(), target)
+		if err != nil {
+			return err
+		}
+		target = resolved
+	}
+	return socks5.ClientHandshake(conn, target, proxyURL.User)
+}
+
 // createProxyTunnel 创建代理隧道
 func createProxyTunnel(ctx *ProxyCtx, addr string) (net.Conn, error) {
-	targetConn, err := tcpConnect(ctx.Req.Context(), addr)
+	proxyURL, err := pool.ParseAddress(addr)
 	if err != nil {
-		ctx.Debug(fmt.Sprintf("tcp连接失败 %s: %s", addr, err.Error()))
 		ctx.Pool.DisableAddress(addr)
-		ctx.Debug(fmt.Sprintf("代理无法连接, 已移除: %s", err))
 		return nil, err
 	}
-	ctx.Debug(fmt.Sprintf("tcp连接成功 %s", addr))
-	reqBytes := createHttpConnectBytes(ctx.Req)
-	_, err = targetConn.Write(reqBytes)
+	targetConn, err := pool.DialProxy(ctx.Req.Context(), proxyURL, ctx.timeouts.dial, ctx.timeouts.handshake)
 	if err != nil {
-		ctx.Debug(fmt.Sprintf("代理隧道建立失败 %s: %s", addr, err.Error()))
+		reason := pool.FailureDial
+		if isTimeout(err) {
+			reason = pool.FailureTimeout
+		}
+		ctx.Debug(fmt.Sprintf("tcp连接失败 %s: %s", pool.RedactAddress(addr), err.Error()), "reason", reason)
+		ctx.Pool.ReportFailure(addr, reason)
 		return nil, err
 	}
-
-	err = checkProxyConnectTunnel(targetConn)
+	ctx.Debug(fmt.Sprintf("tcp连接成功 %s", pool.RedactAddress(addr)))
+	tunnelConn := targetConn
+	switch proxyURL.Scheme {
+	case pool.ProtocolSocks5, pool.ProtocolSocks5H:
+		err = handshakeSocks5Proxy(ctx, targetConn, proxyURL)
+	default:
+		tunnelConn, err = handshakeHttpProxy(ctx, targetConn, proxyURL)
+	}
 	if err != nil {
-		ctx.Debug(fmt.Sprintf("代理隧道连通性检查未通过 %s: %s", addr, err.Error()))
+		reason := failureReason(err)
+		ctx.Debug(fmt.Sprintf("代理隧道建立失败 %s: %s", pool.RedactAddress(addr), err.Error()), "reason", reason)
+		ctx.Pool.ReportFailure(addr, reason)
+		targetConn.Close()
 		return nil, err
 	}
-	return targetConn, nil
+	targetConn.SetDeadline(time.Time{})
+	ctx.Pool.ReportSuccess(addr)
+	return tunnelConn, nil
 }
 
-// tryCreateProxyTunnel 重试创建代理隧道
+// tryCreateProxyTunnel 重试创建代理隧道, 最多尝试规则允许的代理地址数量
 func tryCreateProxyTunnel(ctx *ProxyCtx) (net.Conn, error) {
-	addr, err := ctx.Pool.GetAddress()
-	if err != nil {
-		ctx.Debug(fmt.Sprintf("获取代理地址失败: %s", err))
-		return nil, err
+	attempts := ctx.Rule.Attempts()
+	tried := make(map[string]bool, attempts)
+	var lastErr error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		// 归还上一次失败的地址
+		ctx.releaseProxyAddr()
+		addr, err := ctx.acquireProxyAddr()
+		if err != nil {
+			ctx.Debug(fmt.Sprintf("获取代理地址失败: %s", err))
+			if lastErr != nil {
+				break
+			}
+			return nil, err
+		}
+		if tried[addr] {
+			ctx.Debug(fmt.Sprintf("没有其他可用的代理地址, 停止重试: %s", pool.RedactAddress(addr)))
+			break
+		}
+		tried[addr] = true
+		ctx.Debug(fmt.Sprintf("获取代理地址: %s", pool.RedactAddress(addr)))
+		start := time.Now()
+		targetConn, err := createProxyTunnel(ctx, addr)
+		if err == nil {
+			upstreamDialSeconds.Observe(time.Since(start).Seconds(), "success")
+			return targetConn, nil
+		}
+		upstreamDialSeconds.Observe(time.Since(start).Seconds(), "failure")
+		lastErr = err
+		ctx.Debug(fmt.Sprintf("第 %d 次尝试失败 %s", attempt, pool.RedactAddress(addr)))
 	}
-	ctx.Debug(fmt.Sprintf("获取代理地址: %s", addr))
-	targetConn, err := createProxyTunnel(ctx, addr)
-	return targetConn, err
+	return nil, lastErr
 }
 
-// checkHostnameNeedProxy 检查是否需要代理
+// checkHostnameNeedProxy 检查是否需要代理, 规则在请求进入时已匹配
 func checkHostnameNeedProxy(ctx *ProxyCtx) bool {
-	host := ctx.Req.Host
-	ctx.Debug(fmt.Sprintf("检查主机名 %s 是否需要代理", host))
-	for _, regStr := range ctx.conf.ProxyHost {
-		reg := regexp.MustCompile(regStr)
-		if reg.MatchString(host) {
-			ctx.Debug(fmt.Sprintf("主机名 %s 命中代理规则 %s", host, regStr))
-			return true
-		}
-	}
-	ctx.Debug(fmt.Sprintf("主机名 %s 未命中代理规则", host))
-	return false
+	ctx.Debug(fmt.Sprintf("主机名 %s 命中规则 %s", ctx.Req.Host, ctx.Rule))
+	return ctx.Rule.Action == rule.ActionProxy
 }
 
-// HijackConnectHandle 劫持http连接处理
-func HijackConnectHandle(ctx *ProxyCtx, clientConn net.Conn) {
-	var targetConn net.Conn
+// dialTarget 按规则连接目标, 需要代理时通过远程代理建立隧道, 远程代理不可用时按规则降级
+// 规则不允许降级时返回 errProxyUnavailable, ctx.route 记录实际的路由方式
+func dialTarget(ctx *ProxyCtx) (net.Conn, error) {
+	ctx.route = routeDirect
 	if checkHostnameNeedProxy(ctx) {
 		conn, err := tryCreateProxyTunnel(ctx)
-		if err != nil {
-			ctx.Debug(fmt.Sprintf("当前远程代理不可用，降级为本地请求"))
-		} else {
-			targetConn = conn
+		if err == nil {
+			ctx.route = routeProxy
+			return conn, nil
 		}
-	}
-	if targetConn == nil {
-		conn, err := tcpConnect(ctx.Req.Context(), ctx.Req.Host)
-		if err != nil {
-			httpError(ctx, clientConn, err)
-			return
+		if ctx.Rule.Fallback != rule.FallbackDirect {
+			ctx.Warn(fmt.Sprintf("远程代理不可用: %s", err))
+			ctx.route = routeFailed
+			return nil, fmt.Errorf("%w: %s", errProxyUnavailable, err)
 		}
-		targetConn = conn
+		ctx.Debug(fmt.Sprintf("当前远程代理不可用，降级为本地请求"))
+		ctx.route = routeFallback
 	}
-	clientConn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
-	ctx.Debug("隧道建立, 开始正式传输")
+	return tcpConnect(ctx.Req.Context(), ctx.Req.Host, ctx.timeouts.dial)
+}
+
+// relayTunnel 在客户端与目标之间双向转发数据, 两个方向都结束后返回
+func relayTunnel(ctx *ProxyCtx, clientConn net.Conn, targetConn net.Conn) {
 	targetTCP, targetOK := targetConn.(halfClosable)
 	proxyClientTCP, clientOK := clientConn.(halfClosable)
-	if !targetOK || !clientOK {
-		var wg sync.WaitGroup
-		wg.Add(2)
-		go copyOrWarn(ctx, targetConn, clientConn, &wg)
-		go copyOrWarn(ctx, clientConn, targetConn, &wg)
-		wg.Wait()
+	// 空闲超时后关闭两端连接, 两个方向的复制随之结束
+	idle := watchIdle(ctx.timeouts.idle, func() {
+		ctx.Debug(fmt.Sprintf("隧道空闲超过 %s, 关闭连接", ctx.timeouts.idle))
 		clientConn.Close()
 		targetConn.Close()
-		return
+	})
+	defer idle.stop()
+	var wg sync.WaitGroup
+	wg.Add(2)
+	if !targetOK || !clientOK {
+		go copyOrWarn(ctx, targetConn, clientConn, idle, directionUpload, &wg)
+		go copyOrWarn(ctx, clientConn, targetConn, idle, directionDownload, &wg)
 	} else {
-		go copyAndClose(ctx, targetTCP, proxyClientTCP)
-		go copyAndClose(ctx, proxyClientTCP, targetTCP)
+		go copyAndClose(ctx, targetTCP, proxyClientTCP, idle, directionUpload, &wg)
+		go copyAndClose(ctx, proxyClientTCP, targetTCP, idle, directionDownload, &wg)
 	}
+	wg.Wait()
+	clientConn.Close()
+	targetConn.Close()
+}
+
+// HijackConnectHandle 劫持http连接处理
+func HijackConnectHandle(ctx *ProxyCtx, clientConn net.Conn) {
+	// 等待隧道关闭后再归还代理地址
+	defer ctx.releaseProxyAddr()
+	targetConn, err := dialTarget(ctx)
+	requestsTotal.Inc(modeConnect, ctx.route)
+	if err != nil {
+		httpError(ctx, clientConn, err)
+		return
+	}
+	clientConn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+	ctx.Debug("隧道建立, 开始正式传输")
+	relayTunnel(ctx, clientConn, targetConn)
 }
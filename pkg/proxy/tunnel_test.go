@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"easy-http-proxy-pool/pkg/pool"
+	"easy-http-proxy-pool/pkg/socks5"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCheckProxyConnectTunnel(t *testing.T) {
+	check := func(reply string) (net.Conn, error) {
+		client, server := net.Pipe()
+		go func() {
+			server.Write([]byte(reply))
+			server.Close()
+		}()
+		req, _ := http.NewRequest(http.MethodConnect, "http://example.com:443", nil)
+		return checkProxyConnectTunnel(client, req)
+	}
+	t.Run("任意 2xx 均视为成功并保留已读数据", func(t *testing.T) {
+		conn, err := check("HTTP/1.0 201 Created\r\nX-Test: 1\r\n\r\nhello")
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(conn)
+		if string(data) != "hello" {
+			t.Fatal(string(data))
+		}
+	})
+	t.Run("区分认证失败与拒绝访问", func(t *testing.T) {
+		cases := map[string]string{
+			"HTTP/1.1 407 Proxy Authentication Required\r\n\r\n": pool.FailureAuth,
+			"HTTP/1.1 403 Forbidden\r\n\r\n":                     pool.FailureForbidden,
+			"HTTP/1.1 502 Bad Gateway\r\n\r\n":                   pool.FailureRejected,
+			"SSH-2.0-OpenSSH\r\n":                                pool.FailureHandshake,
+		}
+		for reply, reason := range cases {
+			_, err := check(reply)
+			if err == nil {
+				t.Fatal("should fail:", reply)
+			}
+			if r := failureReason(err); r != reason {
+				t.Fatal(reply, r)
+			}
+		}
+	})
+	t.Run("socks5 错误", func(t *testing.T) {
+		cases := map[error]string{
+			fmt.Errorf("%w: proxy requires authentication", socks5.ErrAuthFailed): pool.FailureAuth,
+			&socks5.ReplyError{Code: socks5.ReplyNotAllowed}:                      pool.FailureForbidden,
+			&socks5.ReplyError{Code: 0x05}:                                        pool.FailureRejected,
+			errors.New("EOF"):                                                     pool.FailureHandshake,
+		}
+		for err, reason := range cases {
+			if r := failureReason(err); r != reason {
+				t.Fatal(err, r)
+			}
+		}
+	})
+}
+
+func TestCreateHttpConnectBytes(t *testing.T) {
+	req := httptest.NewRequest(http.MethodConnect, "example.com:443", nil)
+	header := http.Header{"X-Test": {"1"}}
+	data := string(createHttpConnectBytes(req, header, url.UserPassword("vendor", "pw")))
+	want := "CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\nX-Test: 1\r\nProxy-Authorization: Basic dmVuZG9yOnB3\r\n\r\n"
+	if data != want {
+		t.Fatalf("%q", data)
+	}
+	if data := string(createHttpConnectBytes(req, header, nil)); strings.Contains(data, "Proxy-Authorization") {
+		t.Fatalf("%q", data)
+	}
+}
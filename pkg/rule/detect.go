@@ -0,0 +1,90 @@
+package rule
+
+import (
+	"easy-http-proxy-pool/pkg/conf"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+)
+
+// defaultDetectBodyBytes 检测响应体时默认读取的字节数
+const defaultDetectBodyBytes = 4 << 10
+
+// 封禁检测命中的条件
+const (
+	DetectStatus   = "status"
+	DetectHeader   = "header"
+	DetectBody     = "body"
+	DetectLocation = "location"
+)
+
+// Detector 预编译的封禁检测条件, 任一条件满足即视为代理地址被目标站点封禁
+type Detector struct {
+	conf.Detect
+	body     *regexp.Regexp
+	location *regexp.Regexp
+}
+
+// compileDetector 校验并编译检测条件, 未配置时返回 nil
+func compileDetector(c *conf.Detect) (*Detector, error) {
+	if c == nil {
+		return nil, nil
+	}
+	d := &Detector{Detect: *c}
+	for _, code := range d.Status {
+		if code < 100 || code > 599 {
+			return nil, fmt.Errorf("无效的检测状态码: %d", code)
+		}
+	}
+	if d.Body != "" {
+		reg, err := regexp.Compile(d.Body)
+		if err != nil {
+			return nil, err
+		}
+		d.body = reg
+		if d.BodyBytes <= 0 {
+			d.BodyBytes = defaultDetectBodyBytes
+		}
+	}
+	if d.Location != "" {
+		reg, err := regexp.Compile(d.Location)
+		if err != nil {
+			return nil, err
+		}
+		d.location = reg
+	}
+	return d, nil
+}
+
+// NeedsBody 是否需要读取响应体, 返回读取的字节数
+func (d *Detector) NeedsBody() (int, bool) {
+	return d.BodyBytes, d.body != nil
+}
+
+// ChecksLocation 是否检查跳转地址, 检查时请求不能自动跟随跳转
+func (d *Detector) ChecksLocation() bool {
+	return d.location != nil
+}
+
+// Match 检查响应是否为封禁页面, 命中时返回命中的条件
+// body 为解压后的响应体前缀, 未配置响应体条件时可以为空
+func (d *Detector) Match(resp *http.Response, body []byte) (string, bool) {
+	if slices.Contains(d.Status, resp.StatusCode) {
+		return DetectStatus, true
+	}
+	for _, name := range d.Headers {
+		if _, ok := resp.Header[http.CanonicalHeaderKey(name)]; ok {
+			return DetectHeader, true
+		}
+	}
+	if d.location != nil {
+		if location := resp.Header.Get("Location"); location != "" && d.location.MatchString(location) {
+			return DetectLocation, true
+		}
+	}
+	if d.body != nil && d.body.Match(body) {
+		return DetectBody, true
+	}
+	return "", false
+}
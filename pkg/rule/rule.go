@@ -0,0 +1,277 @@
+package rule
+
+import (
+	"context"
+	"easy-http-proxy-pool/pkg/conf"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// 规则动作
+// ActionBlock 直接断开连接
+// ActionReject 返回指定状态码
+const (
+	ActionDirect = "direct"
+	ActionProxy  = "proxy"
+	ActionBlock  = "block"
+	ActionReject = "reject"
+)
+
+// 代理不可用时的处理
+// FallbackDirect 降级为本机直连
+// FallbackFail 返回 502
+// FallbackRetry 更换代理地址重试, 全部失败后返回 502
+const (
+	FallbackDirect = "direct"
+	FallbackFail   = "fail"
+	FallbackRetry  = "retry-other-proxy"
+)
+
+// defaultMaxAttempts retry-other-proxy 默认最多尝试的代理地址数量
+const defaultMaxAttempts = 3
+
+// Target 待匹配的请求
+type Target struct {
+	Host   string
+	Port   int
+	Method string
+	Path   string
+	// raw 客户端请求中原始的 host[:port], 未转小写也未去掉端口
+	raw string
+	// ips 目标为域名时, 首次匹配 CIDR 规则才解析
+	ips      []net.IP
+	resolved bool
+}
+
+// NewTarget 由 host[:port] 构建匹配目标, 缺少端口时使用 defaultPort
+func NewTarget(hostport string, defaultPort int, method string, path string) *Target {
+	host, portStr, err := net.SplitHostPort(hostport)
+	port := defaultPort
+	if err != nil {
+		host = hostport
+	} else if p, err := strconv.Atoi(portStr); err == nil {
+		port = p
+	}
+	return &Target{
+		Host:   strings.ToLower(strings.Trim(host, "[]")),
+		Port:   port,
+		Method: method,
+		Path:   path,
+		raw:    hostport,
+	}
+}
+
+// resolve 返回目标 IP, 解析失败时为空
+func (t *Target) resolve(ctx context.Context) []net.IP {
+	if t.resolved {
+		return t.ips
+	}
+	t.resolved = true
+	if ip := net.ParseIP(t.Host); ip != nil {
+		t.ips = []net.IP{ip}
+		return t.ips
+	}
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip", t.Host)
+	if err == nil {
+		t.ips = ips
+	}
+	return t.ips
+}
+
+// Rule 预编译的规则
+// Rule.legacy 由 host 配置转换的规则, 正则与旧版本一致, 匹配原始的 host[:port]
+type Rule struct {
+	conf.Rule
+	legacy   bool
+	domain   string
+	regex    *regexp.Regexp
+	cidr     *net.IPNet
+	detector *Detector
+}
+
+// defaultRule 所有规则都未命中时直连
+var defaultRule = &Rule{Rule: conf.Rule{Action: ActionDirect, Fallback: FallbackDirect}}
+
+// compile 校验并编译规则, pools 为代理池名称与其代理源, 默认代理池名称为空
+func compile(c *conf.Rule, pools map[string][]string) (*Rule, error) {
+	r := &Rule{Rule: *c}
+	if r.Action == "" {
+		r.Action = ActionProxy
+	}
+	switch r.Action {
+	case ActionDirect, ActionProxy, ActionBlock:
+	case ActionReject:
+		if r.Status == 0 {
+			r.Status = http.StatusForbidden
+		}
+		if r.Status < 100 || r.Status > 599 {
+			return nil, fmt.Errorf("无效的状态码: %d", r.Status)
+		}
+	default:
+		return nil, fmt.Errorf("未知的规则动作: %s", r.Action)
+	}
+	switch r.Fallback {
+	case "":
+		r.Fallback = FallbackDirect
+	case FallbackDirect, FallbackFail:
+	case FallbackRetry:
+		if r.MaxAttempts <= 0 {
+			r.MaxAttempts = defaultMaxAttempts
+		}
+	default:
+		return nil, fmt.Errorf("未知的降级策略: %s", r.Fallback)
+	}
+	sources, ok := pools[r.Pool]
+	if !ok {
+		return nil, fmt.Errorf("代理池不存在: %s", r.Pool)
+	}
+	for _, name := range r.Sources {
+		if !slices.Contains(sources, name) {
+			return nil, fmt.Errorf("代理源不存在: %s", name)
+		}
+	}
+	r.domain = strings.ToLower(strings.TrimPrefix(r.Domain, "."))
+	r.Host = strings.ToLower(r.Host)
+	if r.Regex != "" {
+		reg, err := regexp.Compile(r.Regex)
+		if err != nil {
+			return nil, err
+		}
+		r.regex = reg
+	}
+	if r.CIDR != "" {
+		_, cidr, err := net.ParseCIDR(r.CIDR)
+		if err != nil {
+			return nil, err
+		}
+		r.cidr = cidr
+	}
+	detector, err := compileDetector(r.Detect)
+	if err != nil {
+		return nil, err
+	}
+	r.detector = detector
+	return r, nil
+}
+
+// Detector 封禁检测条件, 未配置时为 nil
+func (r *Rule) Detector() *Detector {
+	return r.detector
+}
+
+// match 所有已配置的条件都满足时命中, 开销大的条件放在最后
+func (r *Rule) match(ctx context.Context, t *Target) bool {
+	if r.domain != "" && t.Host != r.domain && !strings.HasSuffix(t.Host, "."+r.domain) {
+		return false
+	}
+	if r.Host != "" && t.Host != r.Host {
+		return false
+	}
+	if r.Port != 0 && t.Port != r.Port {
+		return false
+	}
+	if r.Method != "" && !strings.EqualFold(t.Method, r.Method) {
+		return false
+	}
+	if r.Path != "" && !strings.HasPrefix(t.Path, r.Path) {
+		return false
+	}
+	if r.regex != nil {
+		host := t.Host
+		if r.legacy {
+			host = t.raw
+		}
+		if !r.regex.MatchString(host) {
+			return false
+		}
+	}
+	if r.cidr != nil && !slices.ContainsFunc(t.resolve(ctx), r.cidr.Contains) {
+		return false
+	}
+	return true
+}
+
+// Attempts 本次请求最多尝试的代理地址数量
+func (r *Rule) Attempts() int {
+	if r.Fallback == FallbackRetry {
+		return r.MaxAttempts
+	}
+	return 1
+}
+
+// String 规则描述, 用于日志
+func (r *Rule) String() string {
+	var parts []string
+	add := func(key string, value string) {
+		if value != "" {
+			parts = append(parts, key+"="+value)
+		}
+	}
+	add("domain", r.Domain)
+	add("host", r.Host)
+	add("regex", r.Regex)
+	add("cidr", r.CIDR)
+	if r.Port != 0 {
+		add("port", strconv.Itoa(r.Port))
+	}
+	add("method", r.Method)
+	add("path", r.Path)
+	if len(parts) == 0 {
+		parts = append(parts, "default")
+	}
+	action := r.Action
+	if r.Pool != "" {
+		action += "(" + r.Pool + ")"
+	}
+	return strings.Join(parts, ",") + " -> " + action
+}
+
+// Engine 规则列表, 配置加载或重新加载时编译一次
+type Engine struct {
+	rules []*Rule
+}
+
+// Compile 编译配置中的规则, ProxyHost 作为 proxy 正则规则追加在最后
+// ProxyHost 的正则匹配原始的 host[:port], 与 rules 中的 regex 不同, 保证旧配置的行为不变
+func Compile(config *conf.Config) (*Engine, error) {
+	sources := make([]string, len(config.ProxySources))
+	for i, item := range config.ProxySources {
+		sources[i] = item.Name
+	}
+	pools := map[string][]string{"": sources}
+	for _, item := range config.Pools {
+		pools[item.Name] = item.Sources
+	}
+	e := &Engine{}
+	for i, item := range config.Rules {
+		r, err := compile(item, pools)
+		if err != nil {
+			return nil, fmt.Errorf("第 %d 条规则无效: %w", i+1, err)
+		}
+		e.rules = append(e.rules, r)
+	}
+	for _, regStr := range config.ProxyHost {
+		r, err := compile(&conf.Rule{Regex: regStr, Action: ActionProxy}, pools)
+		if err != nil {
+			return nil, fmt.Errorf("代理规则 %s 无效: %w", regStr, err)
+		}
+		r.legacy = true
+		e.rules = append(e.rules, r)
+	}
+	return e, nil
+}
+
+// Match 返回第一条命中的规则, 都未命中时返回直连规则
+func (e *Engine) Match(ctx context.Context, t *Target) *Rule {
+	for _, r := range e.rules {
+		if r.match(ctx, t) {
+			return r
+		}
+	}
+	return defaultRule
+}
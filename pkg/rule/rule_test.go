@@ -0,0 +1,116 @@
+package rule
+
+import (
+	"context"
+	"easy-http-proxy-pool/pkg/conf"
+	"net/http"
+	"testing"
+)
+
+func TestEngineMatch(t *testing.T) {
+	config := &conf.Config{
+		ProxyHost:    []string{`.+\.legacy\.com`, `.*:8443$`, `^API\.Legacy\.org`},
+		ProxySources: []*conf.ProxySource{{Name: "a"}},
+		Rules: []*conf.Rule{
+			{Domain: "example.com", Path: "/api", Action: ActionProxy, Sources: []string{"a"}},
+			{Domain: "example.com", Action: ActionDirect},
+			{CIDR: "10.0.0.0/8", Port: 22, Action: ActionBlock},
+			{Host: "ads.test", Action: ActionReject},
+		},
+	}
+	e, err := Compile(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name   string
+		target *Target
+		action string
+	}{
+		{"域名后缀与路径", NewTarget("www.example.com", 80, "GET", "/api/v1"), ActionProxy},
+		{"域名后缀", NewTarget("EXAMPLE.com:443", 443, "CONNECT", ""), ActionDirect},
+		{"网段与端口", NewTarget("10.1.2.3:22", 443, "CONNECT", ""), ActionBlock},
+		{"端口不匹配", NewTarget("10.1.2.3:80", 443, "CONNECT", ""), ActionDirect},
+		{"完整主机名", NewTarget("ads.test", 80, "GET", "/"), ActionReject},
+		{"兼容 host 配置", NewTarget("www.legacy.com:443", 443, "CONNECT", ""), ActionProxy},
+		{"host 配置匹配原始端口", NewTarget("other.org:8443", 443, "CONNECT", ""), ActionProxy},
+		{"host 配置匹配原始大小写", NewTarget("API.Legacy.org", 80, "GET", "/"), ActionProxy},
+		{"host 配置区分大小写", NewTarget("api.legacy.org", 80, "GET", "/"), ActionDirect},
+		{"未命中", NewTarget("other.org", 80, "GET", "/"), ActionDirect},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := e.Match(context.Background(), c.target)
+			if r.Action != c.action {
+				t.Fatalf("expected %s, got %s", c.action, r)
+			}
+		})
+	}
+	if r := e.Match(context.Background(), NewTarget("ads.test", 80, "GET", "/")); r.Status != 403 {
+		t.Fatalf("expected default status 403, got %d", r.Status)
+	}
+}
+
+func TestCompileInvalid(t *testing.T) {
+	cases := []struct {
+		name string
+		rule *conf.Rule
+	}{
+		{"未知动作", &conf.Rule{Action: "drop"}},
+		{"代理源不存在", &conf.Rule{Sources: []string{"missing"}}},
+		{"代理池不存在", &conf.Rule{Pool: "missing"}},
+		{"无效正则", &conf.Rule{Regex: "("}},
+		{"无效网段", &conf.Rule{CIDR: "10.0.0.0"}},
+		{"未知降级策略", &conf.Rule{Fallback: "retry"}},
+		{"无效检测状态码", &conf.Rule{Detect: &conf.Detect{Status: []int{1000}}}},
+		{"无效检测正则", &conf.Rule{Detect: &conf.Detect{Body: "("}}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if _, err := Compile(&conf.Config{Rules: []*conf.Rule{c.rule}}); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
+
+func TestDetector(t *testing.T) {
+	d, err := compileDetector(&conf.Detect{
+		Status:   []int{429},
+		Headers:  []string{"cf-mitigated"},
+		Body:     "captcha|验证码",
+		Location: "/sorry/",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	newResponse := func(status int, header http.Header) *http.Response {
+		if header == nil {
+			header = http.Header{}
+		}
+		return &http.Response{StatusCode: status, Header: header}
+	}
+	cases := []struct {
+		name      string
+		resp      *http.Response
+		body      string
+		condition string
+	}{
+		{"状态码", newResponse(429, nil), "", DetectStatus},
+		{"头部", newResponse(403, http.Header{"Cf-Mitigated": {"challenge"}}), "", DetectHeader},
+		{"响应体", newResponse(200, nil), "<title>请输入验证码</title>", DetectBody},
+		{"跳转地址", newResponse(302, http.Header{"Location": {"https://www.google.com/sorry/index"}}), "", DetectLocation},
+		{"正常响应", newResponse(200, nil), "hello", ""},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			condition, _ := d.Match(c.resp, []byte(c.body))
+			if condition != c.condition {
+				t.Fatalf("expected %q, got %q", c.condition, condition)
+			}
+		})
+	}
+	if n, ok := d.NeedsBody(); !ok || n != defaultDetectBodyBytes {
+		t.Fatal(n, ok)
+	}
+}
@@ -0,0 +1,214 @@
+package socks5
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+)
+
+// socks5 协议常量 RFC 1928 / RFC 1929
+const (
+	Version5           = 0x05
+	MethodNoAuth       = 0x00
+	MethodUserPass     = 0x02
+	MethodNoAcceptable = 0xff
+	CmdConnect         = 0x01
+	AtypIPv4           = 0x01
+	AtypDomain         = 0x03
+	AtypIPv6           = 0x04
+	userPassVersion    = 0x01
+)
+
+// replyMessages 应答码说明
+var replyMessages = map[byte]string{
+	0x01: "general SOCKS server failure",
+	0x02: "connection not allowed by ruleset",
+	0x03: "network unreachable",
+	0x04: "host unreachable",
+	0x05: "connection refused",
+	0x06: "TTL expired",
+	0x07: "command not supported",
+	0x08: "address type not supported",
+}
+
+// ReplyNotAllowed 代理规则不允许连接目标
+const ReplyNotAllowed = 0x02
+
+// ErrAuthFailed 代理要求认证或认证失败
+var ErrAuthFailed = errors.New("socks5 authentication failed")
+
+// ReplyError 代理拒绝连接目标时的应答码
+type ReplyError struct {
+	Code   byte
+	Target string
+}
+
+func (e *ReplyError) Error() string {
+	msg, ok := replyMessages[e.Code]
+	if !ok {
+		msg = fmt.Sprintf("unknown reply code %d", e.Code)
+	}
+	return fmt.Sprintf("socks5 connect %s: %s", e.Target, msg)
+}
+
+// ResolveTarget 在本地解析 target 中的域名, socks5 协议由客户端负责解析
+func ResolveTarget(ctx context.Context, target string) (string, error) {
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		return "", err
+	}
+	if net.ParseIP(host) != nil {
+		return target, nil
+	}
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip", host)
+	if err != nil {
+		return "", err
+	}
+	if len(ips) == 0 {
+		return "", fmt.Errorf("no such host: %s", host)
+	}
+	return net.JoinHostPort(ips[0].String(), port), nil
+}
+
+// appendAddr 按 ATYP + ADDR + PORT 格式编码地址
+func appendAddr(b []byte, target string) ([]byte, error) {
+	host, portStr, err := net.SplitHostPort(target)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 0 || port > 0xffff {
+		return nil, fmt.Errorf("invalid port: %s", portStr)
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			b = append(b, AtypIPv4)
+			b = append(b, ip4...)
+		} else {
+			b = append(b, AtypIPv6)
+			b = append(b, ip.To16()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return nil, fmt.Errorf("host too long: %s", host)
+		}
+		b = append(b, AtypDomain, byte(len(host)))
+		b = append(b, host...)
+	}
+	return append(b, byte(port>>8), byte(port)), nil
+}
+
+// readAddr 读取 ATYP + ADDR + PORT 格式的地址
+func readAddr(r io.Reader) (string, error) {
+	var atyp [1]byte
+	if _, err := io.ReadFull(r, atyp[:]); err != nil {
+		return "", err
+	}
+	var host string
+	switch atyp[0] {
+	case AtypIPv4, AtypIPv6:
+		size := net.IPv4len
+		if atyp[0] == AtypIPv6 {
+			size = net.IPv6len
+		}
+		ip := make(net.IP, size)
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", err
+		}
+		host = ip.String()
+	case AtypDomain:
+		var size [1]byte
+		if _, err := io.ReadFull(r, size[:]); err != nil {
+			return "", err
+		}
+		domain := make([]byte, size[0])
+		if _, err := io.ReadFull(r, domain); err != nil {
+			return "", err
+		}
+		host = string(domain)
+	default:
+		return "", fmt.Errorf("unknown address type: %d", atyp[0])
+	}
+	var port [2]byte
+	if _, err := io.ReadFull(r, port[:]); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(port[0])<<8|int(port[1]))), nil
+}
+
+// ClientHandshake 在已连接到 socks5 代理的 conn 上完成认证并请求连接 target
+// user 不为空时使用用户名密码认证
+func ClientHandshake(conn io.ReadWriter, target string, user *url.Userinfo) error {
+	methods := []byte{Version5, 1, MethodNoAuth}
+	if user != nil {
+		methods = []byte{Version5, 2, MethodNoAuth, MethodUserPass}
+	}
+	if _, err := conn.Write(methods); err != nil {
+		return err
+	}
+	var reply [2]byte
+	if _, err := io.ReadFull(conn, reply[:]); err != nil {
+		return err
+	}
+	if reply[0] != Version5 {
+		return fmt.Errorf("unexpected socks version: %d", reply[0])
+	}
+	switch reply[1] {
+	case MethodNoAuth:
+	case MethodUserPass:
+		if user == nil {
+			return fmt.Errorf("%w: proxy requires authentication", ErrAuthFailed)
+		}
+		if err := userPassAuth(conn, user); err != nil {
+			return err
+		}
+	default:
+		return errors.New("no acceptable socks5 authentication methods")
+	}
+
+	req, err := appendAddr([]byte{Version5, CmdConnect, 0x00}, target)
+	if err != nil {
+		return err
+	}
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+	var head [3]byte
+	if _, err := io.ReadFull(conn, head[:]); err != nil {
+		return err
+	}
+	if head[1] != 0x00 {
+		return &ReplyError{Code: head[1], Target: target}
+	}
+	// 丢弃 BND.ADDR 与 BND.PORT
+	_, err = readAddr(conn)
+	return err
+}
+
+// userPassAuth 用户名密码认证 RFC 1929
+func userPassAuth(conn io.ReadWriter, user *url.Userinfo) error {
+	username := user.Username()
+	password, _ := user.Password()
+	if len(username) > 255 || len(password) > 255 {
+		return errors.New("socks5 username or password too long")
+	}
+	b := []byte{userPassVersion, byte(len(username))}
+	b = append(b, username...)
+	b = append(b, byte(len(password)))
+	b = append(b, password...)
+	if _, err := conn.Write(b); err != nil {
+		return err
+	}
+	var reply [2]byte
+	if _, err := io.ReadFull(conn, reply[:]); err != nil {
+		return err
+	}
+	if reply[1] != 0x00 {
+		return ErrAuthFailed
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package socks5
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+)
+
+// 服务端使用的应答码
+const (
+	ReplySucceeded            = 0x00
+	ReplyGeneralFailure       = 0x01
+	ReplyHostUnreachable      = 0x04
+	ReplyCommandNotSupported  = 0x07
+	ReplyAddrTypeNotSupported = 0x08
+)
+
+// ErrCommandNotSupported 客户端请求了 CONNECT 以外的命令
+var ErrCommandNotSupported = errors.New("socks5 command not supported")
+
+// ServerHandshake 在客户端连接上完成认证并读取 CONNECT 请求, 返回目标地址与客户端的用户名
+// check 不为 nil 时要求用户名密码认证, 为 nil 时不认证, 客户端仍可通过用户名传递会话标识
+// 成功后由调用方在连接目标后通过 WriteReply 应答
+func ServerHandshake(conn io.ReadWriter, check func(username string, password string) bool) (target string, username string, err error) {
+	var head [2]byte
+	if _, err := io.ReadFull(conn, head[:]); err != nil {
+		return "", "", err
+	}
+	if head[0] != Version5 {
+		return "", "", fmt.Errorf("unexpected socks version: %d", head[0])
+	}
+	methods := make([]byte, head[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return "", "", err
+	}
+	method := byte(MethodNoAcceptable)
+	switch {
+	case check == nil && slices.Contains(methods, MethodNoAuth):
+		method = MethodNoAuth
+	case slices.Contains(methods, MethodUserPass):
+		method = MethodUserPass
+	}
+	if _, err := conn.Write([]byte{Version5, method}); err != nil {
+		return "", "", err
+	}
+	switch method {
+	case MethodNoAcceptable:
+		return "", "", errors.New("no acceptable socks5 authentication methods")
+	case MethodUserPass:
+		username, err = serverUserPassAuth(conn, check)
+		if err != nil {
+			return "", "", err
+		}
+	}
+
+	var req [3]byte
+	if _, err := io.ReadFull(conn, req[:]); err != nil {
+		return "", "", err
+	}
+	if req[0] != Version5 {
+		return "", "", fmt.Errorf("unexpected socks version: %d", req[0])
+	}
+	if req[1] != CmdConnect {
+		WriteReply(conn, ReplyCommandNotSupported)
+		return "", "", fmt.Errorf("%w: %d", ErrCommandNotSupported, req[1])
+	}
+	target, err = readAddr(conn)
+	if err != nil {
+		WriteReply(conn, ReplyAddrTypeNotSupported)
+		return "", "", err
+	}
+	return target, username, nil
+}
+
+// serverUserPassAuth 读取用户名密码 RFC 1929, check 为 nil 时接受任意密码
+func serverUserPassAuth(conn io.ReadWriter, check func(username string, password string) bool) (string, error) {
+	readField := func() (string, error) {
+		var size [1]byte
+		if _, err := io.ReadFull(conn, size[:]); err != nil {
+			return "", err
+		}
+		field := make([]byte, size[0])
+		if _, err := io.ReadFull(conn, field); err != nil {
+			return "", err
+		}
+		return string(field), nil
+	}
+	var version [1]byte
+	if _, err := io.ReadFull(conn, version[:]); err != nil {
+		return "", err
+	}
+	if version[0] != userPassVersion {
+		return "", fmt.Errorf("unexpected auth version: %d", version[0])
+	}
+	username, err := readField()
+	if err != nil {
+		return "", err
+	}
+	password, err := readField()
+	if err != nil {
+		return "", err
+	}
+	if check != nil && !check(username, password) {
+		conn.Write([]byte{userPassVersion, 0x01})
+		return "", ErrAuthFailed
+	}
+	if _, err := conn.Write([]byte{userPassVersion, 0x00}); err != nil {
+		return "", err
+	}
+	return username, nil
+}
+
+// WriteReply 应答 CONNECT 请求, 绑定地址固定为 0.0.0.0:0
+func WriteReply(w io.Writer, code byte) error {
+	_, err := w.Write([]byte{Version5, code, 0x00, AtypIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
@@ -0,0 +1,58 @@
+package socks5
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"testing"
+)
+
+func TestServerHandshake(t *testing.T) {
+	handshake := func(user *url.Userinfo, check func(string, string) bool) (string, string, error, error) {
+		client, server := net.Pipe()
+		defer client.Close()
+		done := make(chan error, 1)
+		go func() {
+			done <- ClientHandshake(client, "example.com:443", user)
+		}()
+		target, username, err := ServerHandshake(server, check)
+		if err == nil {
+			WriteReply(server, ReplySucceeded)
+		}
+		server.Close()
+		return target, username, err, <-done
+	}
+	check := func(username string, password string) bool {
+		return username == "user-session-abc" && password == "pass"
+	}
+	t.Run("不认证", func(t *testing.T) {
+		target, _, err, clientErr := handshake(nil, nil)
+		if err != nil || clientErr != nil {
+			t.Fatal(err, clientErr)
+		}
+		if target != "example.com:443" {
+			t.Fatal(target)
+		}
+	})
+	t.Run("用户名密码认证", func(t *testing.T) {
+		_, username, err, clientErr := handshake(url.UserPassword("user-session-abc", "pass"), check)
+		if err != nil || clientErr != nil {
+			t.Fatal(err, clientErr)
+		}
+		if username != "user-session-abc" {
+			t.Fatal(username)
+		}
+	})
+	t.Run("认证失败", func(t *testing.T) {
+		_, _, err, clientErr := handshake(url.UserPassword("user", "wrong"), check)
+		if !errors.Is(err, ErrAuthFailed) || !errors.Is(clientErr, ErrAuthFailed) {
+			t.Fatal(err, clientErr)
+		}
+	})
+	t.Run("要求认证时客户端未提供", func(t *testing.T) {
+		_, _, err, clientErr := handshake(nil, check)
+		if err == nil || clientErr == nil {
+			t.Fatal("should fail")
+		}
+	})
+}